@@ -0,0 +1,122 @@
+package rio
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The admin handler exposes the runtime state and the controls of a balancer as JSON endpoints. The paths are
+// relative, so when it is mounted under a prefix in an existing mux, strip the prefix, like this
+//
+//	mux.Handle("/admin/rio/", http.StripPrefix("/admin/rio", rio.NewAdminHandler(balancer)))
+//
+// The endpoints are
+//
+//	GET  /stats                  the balancer stats
+//	GET  /workers                the workers with their pending request counts
+//	GET  /inflight               the requests which are posted and not yet completed
+//	POST /cancel?id=<id>         cancels a request
+//	POST /pause[?for=<duration>] pauses the dispatching, optionally resuming after the duration, like 30s
+//	POST /resume                 resumes the dispatching
+//	POST /resize?workers=<count> resizes the worker pool
+type AdminHandler struct {
+	balancer *Balancer
+}
+
+// Use this method to create the admin handler for a balancer
+func NewAdminHandler(b *Balancer) *AdminHandler {
+	return &AdminHandler{balancer: b}
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := "/" + strings.Trim(r.URL.Path, "/")
+	switch path {
+	case "/stats":
+		if allowMethod(w, r, http.MethodGet) {
+			stats, err := h.balancer.Stats()
+			writeResult(w, stats, err)
+		}
+	case "/workers":
+		if allowMethod(w, r, http.MethodGet) {
+			workers, err := h.balancer.Workers()
+			writeResult(w, workers, err)
+		}
+	case "/inflight":
+		if allowMethod(w, r, http.MethodGet) {
+			requests, err := h.balancer.InFlight()
+			writeResult(w, requests, err)
+		}
+	case "/cancel":
+		if allowMethod(w, r, http.MethodPost) {
+			id := r.URL.Query().Get("id")
+			if id == "" {
+				writeError(w, http.StatusBadRequest, "the id parameter is required")
+				return
+			}
+			writeResult(w, map[string]string{"cancelled": id}, h.balancer.Cancel(id))
+		}
+	case "/pause":
+		if allowMethod(w, r, http.MethodPost) {
+			if param := r.URL.Query().Get("for"); param != "" {
+				d, err := time.ParseDuration(param)
+				if err != nil || d <= 0 {
+					writeError(w, http.StatusBadRequest, "the for parameter must be a positive duration, like 30s")
+					return
+				}
+				writeResult(w, map[string]bool{"paused": true}, h.balancer.PauseFor(d))
+				return
+			}
+			writeResult(w, map[string]bool{"paused": true}, h.balancer.Pause())
+		}
+	case "/resume":
+		if allowMethod(w, r, http.MethodPost) {
+			writeResult(w, map[string]bool{"paused": false}, h.balancer.Resume())
+		}
+	case "/resize":
+		if allowMethod(w, r, http.MethodPost) {
+			count, err := strconv.Atoi(r.URL.Query().Get("workers"))
+			if err != nil || count < 1 {
+				writeError(w, http.StatusBadRequest, "the workers parameter must be a positive number")
+				return
+			}
+			writeResult(w, map[string]int{"workers": count}, h.balancer.Resize(count))
+		}
+	default:
+		writeError(w, http.StatusNotFound, "no such endpoint : "+path)
+	}
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed : "+r.Method)
+		return false
+	}
+	return true
+}
+
+func writeResult(w http.ResponseWriter, result interface{}, err error) {
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, result)
+	case ErrRequestNotFound:
+		writeError(w, http.StatusNotFound, err.Error())
+	case ErrBalancerClosed:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
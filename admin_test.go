@@ -0,0 +1,156 @@
+package rio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdminHandlerStatsAndWorkers(t *testing.T) {
+	balancer := GetBalancer(3, 2)
+	server := httptest.NewServer(http.StripPrefix("/admin", NewAdminHandler(balancer)))
+	defer server.Close()
+
+	stats := &Stats{}
+	if status := adminCall(t, server, http.MethodGet, "/admin/stats", stats); status != http.StatusOK || stats.Workers != 3 {
+		t.Fail()
+	}
+
+	var workers []*WorkerInfo
+	if status := adminCall(t, server, http.MethodGet, "/admin/workers", &workers); status != http.StatusOK || len(workers) != 3 {
+		t.Fail()
+	}
+
+	if status := adminCall(t, server, http.MethodPost, "/admin/stats", nil); status != http.StatusMethodNotAllowed {
+		t.Fail()
+	}
+	if status := adminCall(t, server, http.MethodGet, "/admin/unknown", nil); status != http.StatusNotFound {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+
+	if status := adminCall(t, server, http.MethodGet, "/admin/stats", nil); status != http.StatusServiceUnavailable {
+		t.Fail()
+	}
+}
+
+func TestAdminHandlerPauseResumeAndCancel(t *testing.T) {
+	balancer := GetBalancer(1, 2)
+	server := httptest.NewServer(NewAdminHandler(balancer))
+	defer server.Close()
+
+	if status := adminCall(t, server, http.MethodPost, "/pause", nil); status != http.StatusOK {
+		t.Fail()
+	}
+
+	request := BuildRequests(context.Background(), NewFutureTask(Task4).WithSecondTimeout(10))
+	balancer.PostJob(request)
+
+	var requests []*RequestInfo
+	adminCall(t, server, http.MethodGet, "/inflight", &requests)
+	if len(requests) != 1 || requests[0].State != RequestHeld || requests[0].ID != request.ID {
+		t.Fatal("The request should be held while the balancer is paused")
+	}
+
+	if status := adminCall(t, server, http.MethodPost, "/resume", nil); status != http.StatusOK {
+		t.Fail()
+	}
+	<-request.CompletedChannel
+	if response, err := request.GetOnlyResponse(); err != nil || response.Data.(string) != "Response 4" {
+		t.Fail()
+	}
+
+	release := make(chan bool)
+	blocked := BuildRequests(context.Background(), NewFutureTask(blockingTask(release)).WithSecondTimeout(10))
+	balancer.PostJob(blocked)
+
+	if status := adminCall(t, server, http.MethodPost, "/cancel?id="+blocked.ID, nil); status != http.StatusOK {
+		t.Fail()
+	}
+	<-blocked.CompletedChannel
+	close(release)
+	if len(blocked.Responses) != 0 {
+		t.Fail()
+	}
+	if status := adminCall(t, server, http.MethodPost, "/cancel?id="+blocked.ID, nil); status != http.StatusNotFound {
+		t.Fail()
+	}
+
+	stats := &Stats{}
+	adminCall(t, server, http.MethodGet, "/stats", stats)
+	if stats.Completed != 2 || stats.Cancelled != 1 || stats.Pending != 0 {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestAdminHandlerResize(t *testing.T) {
+	balancer := GetBalancer(2, 2)
+	server := httptest.NewServer(NewAdminHandler(balancer))
+	defer server.Close()
+
+	release := make(chan bool)
+	request := BuildRequests(context.Background(), NewFutureTask(blockingTask(release)).WithSecondTimeout(10))
+	balancer.PostJob(request)
+
+	if status := adminCall(t, server, http.MethodPost, "/resize?workers=0", nil); status != http.StatusBadRequest {
+		t.Fail()
+	}
+	if status := adminCall(t, server, http.MethodPost, "/resize?workers=4", nil); status != http.StatusOK {
+		t.Fail()
+	}
+	stats := &Stats{}
+	adminCall(t, server, http.MethodGet, "/stats", stats)
+	if stats.Workers != 4 {
+		t.Fail()
+	}
+
+	// The busy worker is the last one to be retired, so shrinking to 1 keeps it in the pool
+	adminCall(t, server, http.MethodPost, "/resize?workers=1", nil)
+	var workers []*WorkerInfo
+	adminCall(t, server, http.MethodGet, "/workers", &workers)
+	if len(workers) != 1 || workers[0].Pending != 1 || workers[0].Requests[0] != request.ID {
+		t.Fail()
+	}
+
+	close(release)
+	<-request.CompletedChannel
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func adminCall(t *testing.T, server *httptest.Server, method, path string, result interface{}) int {
+	req, _ := http.NewRequest(method, server.URL+path, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if result != nil {
+		if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
+
+func blockingTask(release chan bool) Callback {
+	return func(*BridgeConnection) *FutureTaskResponse {
+		select {
+		case <-release:
+		case <-time.After(10 * time.Second):
+		}
+		return &FutureTaskResponse{ResponseCode: 200, Data: "Released"}
+	}
+}
@@ -2,14 +2,35 @@ package rio
 
 import (
 	"container/heap"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// This error is returned by the control methods of the balancer, once it is closed
+var ErrBalancerClosed = errors.New("the balancer is closed")
+
+// This error is returned when a request with the given id is not queued or in-flight in the balancer
+var ErrRequestNotFound = errors.New("no queued or in-flight request found with the given id")
+
+// This error is returned by PostJob, when the balancer already has as many uncompleted requests as its admission limit
+var ErrAdmissionLimit = errors.New("the balancer admission limit is reached, try again later")
+
 // The balancer struct, this struct is used inside the GetBalancer method to provide a load balancer to the caller
 type Balancer struct {
 
+	// Its the sequence used to generate the request ids. It is kept as the first field, so that it stays 64 bit
+	// aligned for the atomic operations.
+	requestSequence uint64
+
+	// The number of the posted requests which are not completed yet and the maximum allowed, 0 means no limit. These
+	// are accessed atomically, as the admission happens in the posting goroutine.
+	admitted       int64
+	admissionLimit int64
+
 	// Its the pool of Worker, which is itself a priority queue based on min heap.
 	pool Pool
 
@@ -29,43 +50,161 @@ type Balancer struct {
 	// then finally respond by sending boolean true to the passed channel by the caller, confirming that all the inner
 	// loop are closed and the balancer is shutdown.
 	closeChannel chan chan bool
+
+	// This channel is used by the control methods (stats, pause, resize etc.) to run a function inside the balancer
+	// loop, so that the pool and the worker states are only ever touched by one goroutine
+	commandChannel chan func()
+
+	// This channel is closed when the balancer loop exits, so that the control methods do not block after a Close
+	stopped chan struct{}
+
+	// The buffer size of the worker request channel, used to create new workers when the pool is resized
+	taskPerWorker int
+
+	// These are set by the options, see New
+	logger   Logger
+	metrics  Metrics
+	tracer   Tracer
+	strategy DispatchStrategy
+
+	// Its the number of workers created so far, used to name the new workers uniquely
+	workerSequence int
+
+	// The workers removed from the pool by a resize. They are closed once their pending requests are processed.
+	retiring []*Worker
+
+	// When paused, the posted requests are held in the balancer instead of being dispatched to the workers. If the
+	// pause has a deadline, the dispatching is resumed automatically by the resume timer. The requests are held also
+	// when all the workers are full, till one of them is done with a request.
+	paused      bool
+	pausedUntil time.Time
+	resumeTimer *time.Timer
+	held        []*Request
+
+	// The counters reported in the stats
+	completedCount int
+	cancelledCount int
+
+	// The circuit breakers and the rate limiters by the task name, the bulkheads by their name and the rate limiters
+	// by the tenant. These are shared by the workers, hence guarded by the mutex.
+	policiesMu     sync.RWMutex
+	breakers       map[string]*CircuitBreaker
+	bulkheads      map[string]*Bulkhead
+	taskLimiters   map[string]*RateLimiter
+	tenantLimiters map[string]*RateLimiter
+
+	// The defaults for the tasks and the requests, which do not set their own, and the settings of the tasks by their
+	// name, which override their own. These can be changed by a Reload, hence guarded by the policies mutex too.
+	taskTimeout    time.Duration
+	requestTimeout time.Duration
+	retryPolicy    RetryPolicy
+	taskPolicies   map[string]taskPolicy
+
+	// The configuration applied by the last Reload, it is nil if there was none
+	applied *Config
+
+	// The adaptive concurrency limiter, it is nil when the concurrency is not limited
+	limiter ConcurrencyLimiter
 }
 
 // Use this method to create an instance of the balancer/load balancer. This method must be created only one, per
-// the go runtime as it is very much resource intensive.
+// the go runtime as it is very much resource intensive. The arguments are not validated, use New to get an error for
+// the invalid ones.
 func GetBalancer(workerCount, taskPerWorker int) *Balancer {
+	return GetBalancerWithTimeouts(workerCount, taskPerWorker, 0, 0)
+}
+
+// Use this method like GetBalancer, to also set the default timeouts. The task timeout is used for the tasks with a
+// zero timeout and the request timeout for the requests with a zero timeout. A zero default means no timeout. The
+// negative arguments are taken as zero.
+func GetBalancerWithTimeouts(workerCount, taskPerWorker int, taskTimeout, requestTimeout time.Duration) *Balancer {
+	c := defaultConfig()
+	c.workers, c.queueSize = 0, 0
+	if workerCount > 0 {
+		c.workers = workerCount
+	}
+	if taskPerWorker > 0 {
+		c.queueSize = taskPerWorker
+	}
+	c.taskTimeout, c.requestTimeout = 0, 0
+	if taskTimeout > 0 {
+		c.taskTimeout = taskTimeout
+	}
+	if requestTimeout > 0 {
+		c.requestTimeout = requestTimeout
+	}
+	return newBalancer(c)
+}
+
+// Creates the balancer from the validated configuration and starts it
+func newBalancer(c *config) *Balancer {
 	b := &Balancer{
-		done:         make(chan *Worker),
-		jobChannel:   make(chan *Request),
-		closeChannel: make(chan chan bool),
+		done:           make(chan *Worker),
+		jobChannel:     make(chan *Request),
+		closeChannel:   make(chan chan bool),
+		commandChannel: make(chan func()),
+		stopped:        make(chan struct{}),
+		taskPerWorker:  c.queueSize,
+		taskTimeout:    c.taskTimeout,
+		requestTimeout: c.requestTimeout,
+		logger:         c.logger,
+		metrics:        c.metrics,
+		tracer:         c.tracer,
+		strategy:       c.strategy,
+		retryPolicy:    c.retryPolicy,
+		admissionLimit: int64(c.admissionLimit),
+		limiter:        c.limiter,
 	}
-	p := make([]*Worker, workerCount)
-	for i := 0; i < workerCount; i++ {
-		w := &Worker{
-			requests:     make(chan *Request, taskPerWorker),
-			pending:      0,
-			index:        i,
-			Name:         fmt.Sprintf("Worker-%d", i),
-			done:         b.done,
-			closeChannel: make(chan chan bool),
-		}
-		p[i] = w
-		w.Run()
+	b.pool = make(Pool, 0, c.workers)
+	for i := 0; i < c.workers; i++ {
+		heap.Push(&b.pool, b.newWorker())
 	}
-	b.pool = p
 	b.balance()
 	return b
 }
 
 // Use this method from the caller side to queue a new job/request. It will be validated and if found proper, will be
-// passed to the worker to be processed. This method returns immediately
+// passed to the worker to be processed. This method returns immediately. If the admission limit of the balancer is
+// reached, ErrAdmissionLimit is returned, and if the concurrency limit is reached, ErrConcurrencyLimit.
 func (b *Balancer) PostJob(job *Request) error {
+	_, err := b.Submit(job)
+	return err
+}
+
+// Use this method like PostJob, it returns a Future to wait for the request or to get notified on its completion. The
+// CompletedChannel of the request is optional, when the future is used.
+func (b *Balancer) Submit(job *Request) (*Future, error) {
 	err := job.Validate()
 	if err == nil {
+		err = b.admissionError()
+	}
+	if err == nil {
+		b.admit(job)
 		b.jobChannel <- job
-		return nil
+		return job.future, nil
 	}
-	return err
+	b.metrics.RequestRejected(err)
+	return nil, err
+}
+
+// Counts the request in, if the admission and the concurrency limits allow it
+func (b *Balancer) admissionError() error {
+	admitted := atomic.AddInt64(&b.admitted, 1)
+	if limit := atomic.LoadInt64(&b.admissionLimit); limit > 0 && admitted > limit {
+		atomic.AddInt64(&b.admitted, -1)
+		return ErrAdmissionLimit
+	}
+	if limiter := b.concurrencyLimiter(); limiter != nil && admitted > int64(limiter.Limit()) {
+		atomic.AddInt64(&b.admitted, -1)
+		return ErrConcurrencyLimit
+	}
+	return nil
+}
+
+// Use this method to limit the number of the posted requests, which are not completed yet. This includes the requests
+// held while the balancer is paused. Use 0 to remove the limit.
+func (b *Balancer) SetAdmissionLimit(limit int) {
+	atomic.StoreInt64(&b.admissionLimit, int64(limit))
 }
 
 // Use this method to close/shutdown a balancer. When this is called, balancer waits for all the requests to be
@@ -76,18 +215,66 @@ func (b *Balancer) Close(cb chan bool) {
 	b.closeChannel <- cb
 }
 
+// Use this method to cancel a queued or in-flight request by its id. The context of the request is cancelled, so
+// the worker stops processing it and the caller is notified through the CompletedChannel.
+func (b *Balancer) Cancel(id string) error {
+	found := false
+	err := b.do(func() { found = b.cancel(id) })
+	if err == nil && !found {
+		return ErrRequestNotFound
+	}
+	return err
+}
+
+// Use this method to stop dispatching the requests to the workers. The posted requests are held by the balancer
+// until Resume is called, the requests already dispatched to the workers are processed as usual.
+func (b *Balancer) Pause() error {
+	return b.do(func() { b.pause(time.Time{}) })
+}
+
+// Use this method to pause the dispatching for the given duration, after which it is resumed automatically. Calling
+// Resume before that resumes it immediately.
+func (b *Balancer) PauseFor(d time.Duration) error {
+	if d <= 0 {
+		return errors.New("the pause duration must be positive")
+	}
+	return b.do(func() {
+		b.pause(time.Now().Add(d))
+		b.resumeTimer = time.AfterFunc(d, func() { b.do(b.resumeIfDue) })
+	})
+}
+
+// Use this method to resume dispatching, after a Pause. The held requests are dispatched in the order of posting.
+func (b *Balancer) Resume() error {
+	return b.do(b.resume)
+}
+
+// Use this method to grow or shrink the worker pool at runtime. When shrinking, the most lightly loaded workers are
+// taken out of the pool and closed, once they are done with their pending requests.
+func (b *Balancer) Resize(workerCount int) error {
+	if workerCount < 1 {
+		return errors.New("the worker count must be at least 1")
+	}
+	return b.do(func() { b.resize(workerCount) })
+}
+
 // Unexported method. Only used by the balancer to managed the posted requests.
 func (b *Balancer) balance() {
 	go func() {
+		defer close(b.stopped)
 		for {
 			select {
 			case req := <-b.jobChannel:
-				b.dispatch(req)
-				b.queuedItems++
+				b.held = append(b.held, req)
+				b.drain()
 			case w := <-b.done:
 				b.completed(w)
-				b.queuedItems--
+			case command := <-b.commandChannel:
+				command()
 			case cb := <-b.closeChannel:
+				if b.paused {
+					b.resume()
+				}
 				if b.queuedItems > 0 {
 					time.AfterFunc(1*time.Second, func() { b.closeChannel <- cb })
 				} else {
@@ -95,10 +282,9 @@ func (b *Balancer) balance() {
 						c := make(chan bool)
 						w.Close(c)
 						<-c
-						fmt.Println("")
 					}
 					cb <- true
-					log.Println("Closing balancer")
+					b.logger.Printf("Closing balancer")
 					return
 				}
 			}
@@ -107,18 +293,257 @@ func (b *Balancer) balance() {
 
 }
 
-// Balancer uses this method to send a validated request to the most lightly loaded worker
+// Runs the command inside the balancer loop and waits for it to finish
+func (b *Balancer) do(command func()) error {
+	finished := make(chan bool, 1)
+	select {
+	case b.commandChannel <- func() { command(); finished <- true }:
+		<-finished
+		return nil
+	case <-b.stopped:
+		return ErrBalancerClosed
+	}
+}
+
+// Prepares a validated request to be tracked by the balancer, it is called from the posting goroutine
+func (b *Balancer) admit(req *Request) {
+	if req.ID == "" {
+		req.ID = fmt.Sprintf("Request-%d", atomic.AddUint64(&b.requestSequence, 1))
+	}
+	if req.Ctx == nil {
+		req.Ctx = context.Background()
+	}
+	req.callerCtx = req.Ctx
+	if req.Timeout == 0 {
+		b.policiesMu.RLock()
+		req.Timeout = b.requestTimeout
+		b.policiesMu.RUnlock()
+	}
+	if req.Timeout > 0 {
+		req.Ctx, req.cancelFunc = context.WithTimeout(req.Ctx, req.Timeout)
+	} else {
+		req.Ctx, req.cancelFunc = context.WithCancel(req.Ctx)
+	}
+	req.future = newFuture(req)
+	req.postedAt = time.Now()
+	req.taskCount = len(req.Tasks)
+}
+
+// The timeout of a task attempt, it is NoTimeout when neither the task nor the balancer has one. The task policy of
+// the same name overrides the timeout of the task.
+func (b *Balancer) timeoutOf(task *FutureTask) time.Duration {
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	switch {
+	case b.taskPolicies[task.Name].Timeout > 0:
+		return b.taskPolicies[task.Name].Timeout
+	case task.Timeout > 0:
+		return task.Timeout
+	case b.taskTimeout > 0:
+		return b.taskTimeout
+	}
+	return NoTimeout
+}
+
+// The retry count of a task, the retry policy applies when the task does not set one, unless it is NoRetries. The task
+// policy of the same name overrides the retry count of the task.
+func (b *Balancer) retriesOf(task *FutureTask) int {
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	if retries := b.taskPolicies[task.Name].Retries; retries != nil {
+		return *retries
+	}
+	switch {
+	case task.RetryCount == NoRetries:
+		return 0
+	case task.RetryCount > 0:
+		return task.RetryCount
+	}
+	return b.retryPolicy.MaxRetries
+}
+
+// The wait before the retry after the given attempt, as per the retry policy
+func (b *Balancer) backoffOf(attempt int) time.Duration {
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	return b.retryPolicy.backoff(attempt)
+}
+
+// Creates a new worker and starts it
+func (b *Balancer) newWorker() *Worker {
+	w := &Worker{
+		requests:     make(chan *Request, b.taskPerWorker),
+		pending:      0,
+		Name:         fmt.Sprintf("Worker-%d", b.workerSequence),
+		done:         b.done,
+		closeChannel: make(chan chan bool),
+		balancer:     b,
+	}
+	b.workerSequence++
+	w.Run()
+	return w
+}
+
+// Balancer uses this method to send a validated request to the worker picked by the dispatch strategy, which is by
+// default the most lightly loaded worker
 func (b *Balancer) dispatch(req *Request) {
-	w := heap.Pop(&b.pool).(*Worker)
-	log.Println(fmt.Sprintf("Dispatching request to [%s]", w.Name))
+	w := b.strategy.Pick(b.pool, req)
+	if w.pending > b.taskPerWorker {
+		w = b.pool[0]
+	}
+	b.logger.Printf("Dispatching request to [%s]", w.Name)
+	w.inFlight = append(w.inFlight, req)
 	w.DoWork(req)
 	w.pending++
-	heap.Push(&b.pool, w)
+	w.dispatched++
+	b.queuedItems++
+	heap.Fix(&b.pool, w.index)
 }
 
 // Worker when completes a task return to the balancer and its pending count is decreased by 1
 func (b *Balancer) completed(w *Worker) {
+	req := w.inFlight[0]
+	w.inFlight[0] = nil
+	w.inFlight = w.inFlight[1:]
+	req.cancelFunc()
+	atomic.AddInt64(&b.admitted, -1)
 	w.pending--
-	worker := heap.Remove(&b.pool, w.index)
-	heap.Push(&b.pool, worker)
+	b.queuedItems--
+	b.completedCount++
+	if w.retiring {
+		if w.pending == 0 {
+			b.removeRetiring(w)
+			closeWorker(w)
+		}
+	} else {
+		heap.Fix(&b.pool, w.index)
+	}
+	b.drain()
+}
+
+// Dispatches the held requests in the order of posting, while the balancer is not paused and the least loaded worker
+// can take one more. A worker can not take more than its queue size and the one it is processing, as the balancer
+// would block on its request channel, while the worker blocks on the done channel. The held requests, which are
+// cancelled or timed out meanwhile, are completed with their context error, to free their admission slots.
+func (b *Balancer) drain() {
+	held := b.held[:0]
+	for _, req := range b.held {
+		if err := req.Ctx.Err(); err != nil {
+			b.drop(req, err)
+		} else {
+			held = append(held, req)
+		}
+	}
+	for i := len(held); i < len(b.held); i++ {
+		b.held[i] = nil
+	}
+	b.held = held
+
+	for !b.paused && len(b.held) > 0 && len(b.pool) > 0 && b.pool[0].pending <= b.taskPerWorker {
+		req := b.held[0]
+		b.held[0] = nil
+		b.held = b.held[1:]
+		b.dispatch(req)
+	}
+}
+
+// Stops the dispatching, with an optional deadline. The timer of an earlier pause is discarded.
+func (b *Balancer) pause(until time.Time) {
+	if b.resumeTimer != nil {
+		b.resumeTimer.Stop()
+		b.resumeTimer = nil
+	}
+	b.paused = true
+	b.pausedUntil = until
+}
+
+// Resumes the dispatching of the held requests
+func (b *Balancer) resume() {
+	if b.resumeTimer != nil {
+		b.resumeTimer.Stop()
+		b.resumeTimer = nil
+	}
+	b.paused = false
+	b.pausedUntil = time.Time{}
+	b.drain()
+}
+
+// Called by the resume timer. The check guards against a timer, which fired while a new pause was being set up.
+func (b *Balancer) resumeIfDue() {
+	if b.paused && !b.pausedUntil.IsZero() && !time.Now().Before(b.pausedUntil) {
+		b.resume()
+	}
+}
+
+// Adds or retires workers, till the pool has the given number of workers
+func (b *Balancer) resize(workerCount int) {
+	for len(b.pool) < workerCount {
+		heap.Push(&b.pool, b.newWorker())
+	}
+	for len(b.pool) > workerCount {
+		w := heap.Pop(&b.pool).(*Worker)
+		w.retiring = true
+		if w.pending == 0 {
+			closeWorker(w)
+		} else {
+			b.retiring = append(b.retiring, w)
+		}
+	}
+	b.drain()
+}
+
+func (b *Balancer) removeRetiring(w *Worker) {
+	for i, r := range b.retiring {
+		if r == w {
+			b.retiring = append(b.retiring[:i], b.retiring[i+1:]...)
+			return
+		}
+	}
+}
+
+// Closes a worker, which is out of the pool, without blocking the balancer loop
+func closeWorker(w *Worker) {
+	go func() {
+		c := make(chan bool)
+		w.Close(c)
+		<-c
+	}()
+}
+
+// Cancels the held or in-flight request with the id, reports if the request is found
+func (b *Balancer) cancel(id string) bool {
+	for i, req := range b.held {
+		if req.ID == id {
+			b.held = append(b.held[:i], b.held[i+1:]...)
+			b.drop(req, context.Canceled)
+			b.cancelledCount++
+			return true
+		}
+	}
+	for _, w := range b.workers() {
+		for _, req := range w.inFlight {
+			if req.ID == id {
+				req.cancelFunc()
+				b.cancelledCount++
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Completes a held request with the error, without dispatching it
+func (b *Balancer) drop(req *Request, err error) {
+	req.cancelFunc()
+	b.report(req, err)
+	atomic.AddInt64(&b.admitted, -1)
+	b.completedCount++
+	req.complete(err)
+}
+
+// All the workers of the balancer, including the retiring ones
+func (b *Balancer) workers() []*Worker {
+	workers := make([]*Worker, 0, len(b.pool)+len(b.retiring))
+	workers = append(workers, b.pool...)
+	return append(workers, b.retiring...)
 }
@@ -516,6 +516,159 @@ func TestWithEmptyCompletedChannel(t *testing.T) {
 
 }
 
+func TestPauseWithDeadlineAndAdmissionLimit(t *testing.T) {
+	balancer := GetBalancer(2, 2)
+	balancer.SetAdmissionLimit(2)
+
+	if balancer.PauseFor(0) == nil {
+		t.Fail()
+	}
+	balancer.PauseFor(500 * time.Millisecond)
+
+	request1 := BuildRequests(context.Background(), NewFutureTask(Task4).WithSecondTimeout(10))
+	request2 := BuildRequests(context.Background(), NewFutureTask(Task4).WithSecondTimeout(10))
+	request3 := BuildRequests(context.Background(), NewFutureTask(Task4).WithSecondTimeout(10))
+	if balancer.PostJob(request1) != nil || balancer.PostJob(request2) != nil {
+		t.Fail()
+	}
+	if err := balancer.PostJob(request3); err != ErrAdmissionLimit {
+		t.Fail()
+	}
+
+	stats, _ := balancer.Stats()
+	if !stats.Paused || stats.PausedUntil == nil || stats.Held != 2 || stats.Admitted != 2 {
+		t.Fail()
+	}
+
+	// The held requests are dispatched once the pause is over
+	<-request1.CompletedChannel
+	<-request2.CompletedChannel
+
+	stats, _ = balancer.Stats()
+	if stats.Paused || stats.PausedUntil != nil || stats.Admitted != 0 {
+		t.Fail()
+	}
+	if balancer.PostJob(request3) != nil {
+		t.Fail()
+	}
+	<-request3.CompletedChannel
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestPauseOverridesEarlierDeadline(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	balancer.PauseFor(100 * time.Millisecond)
+	balancer.Pause()
+	time.Sleep(300 * time.Millisecond)
+
+	stats, _ := balancer.Stats()
+	if !stats.Paused {
+		t.Fail()
+	}
+
+	// Closing a paused balancer dispatches the held requests first
+	request := BuildRequests(context.Background(), NewFutureTask(Task4).WithSecondTimeout(10))
+	balancer.PostJob(request)
+	closeChannel := make(chan bool)
+	go balancer.Close(closeChannel)
+	<-request.CompletedChannel
+	<-closeChannel
+}
+
+func TestWithNegativeSettings(t *testing.T) {
+	requests := []*Request{
+		BuildRequests(context.Background(), NewFutureTask(Task1).WithMilliSecondTimeout(-1)),
+		BuildRequests(context.Background(), NewFutureTask(Task1).WithRetry(-1)),
+		BuildRequests(context.Background(), NewFutureTask(Task1).WithReplica(-2)),
+		BuildRequests(context.Background(), NewFutureTask(Task1).WithFallback(NewFutureTask(nil))),
+		BuildRequests(context.Background(), NewFutureTask(Task1)).WithTimeout(-time.Second),
+		BuildRequests(context.Background(), NewFutureTask(Task1)).FollowedBy(Bridge1, NewFutureTask(nil)),
+	}
+	for _, request := range requests {
+		err := request.Validate()
+		fmt.Println(err)
+		if err == nil {
+			t.Fail()
+		}
+	}
+}
+
+func TestRequestIsHeldWhenAllWorkersAreFull(t *testing.T) {
+	balancer := GetBalancer(2, 1)
+	release := make(chan bool)
+
+	// Every worker takes the request it processes and one queued, so the fifth request finds all of them full
+	var futures []*Future
+	for i := 0; i < 5; i++ {
+		future, err := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(blockingTask(release))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		futures = append(futures, future)
+	}
+
+	// The balancer must keep serving, instead of blocking on a full worker
+	statsChannel := make(chan *Stats, 1)
+	go func() {
+		stats, _ := balancer.Stats()
+		statsChannel <- stats
+	}()
+	select {
+	case stats := <-statsChannel:
+		if stats.Held != 1 {
+			t.Errorf("expected one held request, got : %+v", stats)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the balancer is blocked on the full workers")
+	}
+
+	close(release)
+	for _, future := range futures {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if _, err := future.Wait(ctx); err != nil {
+			t.Error(err)
+		}
+		cancel()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestExpiredHeldRequestIsDropped(t *testing.T) {
+	balancer, _ := New(WithWorkers(1), WithQueueSize(0), WithAdmissionLimit(2))
+	release := make(chan bool)
+
+	busy, _ := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(blockingTask(release))))
+	held, _ := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(Task4)).WithTimeout(20 * time.Millisecond))
+	time.Sleep(40 * time.Millisecond)
+
+	// The worker is still full, the next drain completes the expired request and frees its admission slot
+	balancer.Resize(1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := held.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error : %v", err)
+	}
+	if stats, _ := balancer.Stats(); stats.Held != 0 || stats.Admitted != 1 {
+		t.Errorf("unexpected stats : %+v", stats)
+	}
+	if _, err := busy.Result(); err != ErrNotCompleted {
+		t.Fail()
+	}
+
+	close(release)
+	busy.Wait(context.Background())
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
 func Bridge1(interface{}) *BridgeConnection {
 	return &BridgeConnection{}
 }
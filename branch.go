@@ -0,0 +1,163 @@
+package rio
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// This error is set on the response of a branch step, when its predicate chooses a branch, which does not exist
+var ErrUnknownBranch = errors.New("the predicate has chosen an unknown branch")
+
+// Predicate chooses the branch to run by its name, from the response of the task before the branch step. It gets the
+// failed responses too, so a branch can handle a failure, like a cache miss. An empty name skips all the branches.
+type Predicate func(*Response) string
+
+// Branch is an alternative sub chain of a branch step. Its first bridge connects the task before the branch step to
+// its first task, the rest of them connect its tasks like in a request.
+type Branch struct {
+	Name    string
+	Bridges []Bridge
+	Tasks   []*FutureTask
+}
+
+// Use this method to create a branch with its first task, the bridge gets the data of the response, which the branch
+// is chosen on. The data is nil, when the response has failed.
+func NewBranch(name string, bridge Bridge, task *FutureTask) *Branch {
+	return &Branch{Name: name, Bridges: []Bridge{bridge}, Tasks: []*FutureTask{task}}
+}
+
+// Use this method to chain a task in the branch, like Request.FollowedBy
+func (b *Branch) FollowedBy(bridge Bridge, task *FutureTask) *Branch {
+	b.Bridges = append(b.Bridges, bridge)
+	b.Tasks = append(b.Tasks, task)
+	return b
+}
+
+// This construct is used to choose between the alternative sub chains, like a cache hit or a miss. The predicate
+// chooses the branch to run from the response of the previous task, the tasks of the other branches are skipped.
+//
+// The branch step has a single response in the Responses of the request. Its data is of the last task of the chosen
+// branch, so the chain can continue after it, and its nested responses are of the tasks of all the branches, in
+// order, with the ones of the branches not chosen marked as Skipped. When no branch is chosen, the response is marked
+// as Skipped and has the data of the previous task.
+func (r *Request) Branch(predicate Predicate, branches ...*Branch) *Request {
+	r.Tasks = append(r.Tasks, &FutureTask{Predicate: predicate, Branches: branches})
+	if len(r.Tasks) > 1 {
+		// The branches have their own bridges, the nil slot keeps the tasks matched with their bridges
+		r.Bridges = append(r.Bridges, nil)
+	}
+	return r
+}
+
+// Validates the branches of a branch step, every branch must have a bridge for each of its tasks
+func (f *FutureTask) validateBranches() error {
+	if len(f.Branches) == 0 {
+		return errors.New("the branch step has no branches")
+	}
+	names := make(map[string]bool, len(f.Branches))
+	for i, branch := range f.Branches {
+		switch {
+		case branch == nil:
+			return fmt.Errorf("the branch %d is nil", i)
+		case branch.Name == "":
+			return fmt.Errorf("the branch %d has no name", i)
+		case names[branch.Name]:
+			return fmt.Errorf("the branch name %q is repeated", branch.Name)
+		case len(branch.Tasks) == 0:
+			return fmt.Errorf("the branch %q has no tasks", branch.Name)
+		case len(branch.Bridges) != len(branch.Tasks):
+			return fmt.Errorf("the branch %q has %d tasks and %d bridges, expected a bridge for each task",
+				branch.Name, len(branch.Tasks), len(branch.Bridges))
+		}
+		names[branch.Name] = true
+		for j, task := range branch.Tasks {
+			if err := task.validate(); err != nil {
+				return fmt.Errorf("task %d of the branch %q : %v", j, branch.Name, err)
+			}
+			if branch.Bridges[j] == nil && !task.ownsBridge() {
+				return fmt.Errorf("the bridge to the task %d of the branch %q is nil", j, branch.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// This method runs the branch chosen by the predicate of the branch step, see Request.Branch
+func (w *Worker) branch(r *Request, index, remaining int, task *FutureTask, parent *Response) (*Response, bool, error) {
+	name := task.Predicate(parent)
+
+	var chosen *Branch
+	for _, branch := range task.Branches {
+		if branch.Name == name {
+			chosen = branch
+		}
+	}
+
+	if chosen == nil {
+		response := &Response{ResponseCode: parent.ResponseCode, Data: parent.Data, Skipped: true, Nested: skipped(task.Branches)}
+		if name != "" {
+			w.balancer.logger.Printf("There is no branch named : %s", name)
+			response = &Response{ResponseCode: -1, Error: ErrUnknownBranch, Nested: skipped(task.Branches)}
+		}
+		r.progress(TaskCompleted, index, task, 1, response)
+		return response, false, nil
+	}
+
+	// The deadline is split among the tasks of the chosen branch and the ones after the branch step
+	responses := make([]*Response, 0, len(chosen.Tasks))
+	var elapsed time.Duration
+	last := parent
+	for i, t := range chosen.Tasks {
+		response, broken, err := w.step(r, index, remaining-1+len(chosen.Tasks)-i, t, chosen.Bridges[i], last, i == 0)
+		if err != nil {
+			return nil, false, err
+		}
+		if broken {
+			for j := i; j < len(chosen.Tasks); j++ {
+				responses = append(responses, bridgeError(response.Error))
+			}
+			return &Response{ResponseTime: -1, ResponseCode: -1, Error: response.Error, Nested: merge(task.Branches, chosen, responses)}, true, nil
+		}
+		if response.ResponseTime > 0 {
+			elapsed += response.ResponseTime
+		}
+		responses = append(responses, response)
+		last = response
+	}
+
+	response := &Response{
+		ResponseTime: elapsed,
+		ResponseCode: last.ResponseCode,
+		Data:         last.Data,
+		Error:        last.Error,
+		Fallback:     last.Fallback,
+		Nested:       merge(task.Branches, chosen, responses),
+	}
+	r.progress(TaskCompleted, index, task, 1, response)
+	return response, false, nil
+}
+
+// The nested responses of a branch step, the responses of the chosen branch in its place among the skipped ones
+func merge(branches []*Branch, chosen *Branch, responses []*Response) []*Response {
+	nested := make([]*Response, 0)
+	for _, branch := range branches {
+		if branch == chosen {
+			nested = append(nested, responses...)
+		} else {
+			nested = append(nested, skipped([]*Branch{branch})...)
+		}
+	}
+	return nested
+}
+
+// The skipped responses of all the tasks of the branches
+func skipped(branches []*Branch) []*Response {
+	nested := make([]*Response, 0)
+	for _, branch := range branches {
+		for range branch.Tasks {
+			nested = append(nested, &Response{ResponseCode: -1, Skipped: true})
+		}
+	}
+	return nested
+}
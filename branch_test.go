@@ -0,0 +1,123 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func valueTask(value interface{}, err error) Callback {
+	return func(*BridgeConnection) *FutureTaskResponse {
+		return &FutureTaskResponse{ResponseCode: 200, Data: value, Error: err}
+	}
+}
+
+func echoTask(prefix string) Callback {
+	return func(bconn *BridgeConnection) *FutureTaskResponse {
+		return &FutureTaskResponse{ResponseCode: 200, Data: prefix + bconn.Data[0].(string)}
+	}
+}
+
+func passBridge(data interface{}) *BridgeConnection {
+	if data == nil {
+		return &BridgeConnection{Data: []interface{}{"none"}}
+	}
+	return &BridgeConnection{Data: []interface{}{data}}
+}
+
+func cacheBranch(response *Response) string {
+	if response.Error != nil {
+		return "miss"
+	}
+	return "hit"
+}
+
+func TestBranchChoosesSubChain(t *testing.T) {
+	balancer := GetBalancer(2, 1)
+
+	build := func(cached interface{}, cacheErr error) *Request {
+		return BuildRequests(context.Background(), NewFutureTask(valueTask(cached, cacheErr))).
+			Branch(cacheBranch,
+				NewBranch("hit", passBridge, NewFutureTask(echoTask("cached "))),
+				NewBranch("miss", passBridge, NewFutureTask(echoTask("loaded "))).
+					FollowedBy(passBridge, NewFutureTask(echoTask("stored ")))).
+			FollowedBy(passBridge, NewFutureTask(echoTask("got ")))
+	}
+
+	future, _ := balancer.Submit(build("user", nil))
+	responses, err := future.Wait(context.Background())
+	if err != nil || len(responses) != 3 || responses[2].Data != "got cached user" {
+		t.Fatalf("unexpected result : %v", err)
+	}
+	nested := responses[1].Nested
+	if len(nested) != 3 || nested[0].Skipped || !nested[1].Skipped || !nested[2].Skipped || nested[0].Data != "cached user" {
+		t.Fail()
+	}
+
+	// The miss branch gets the failed response without data, and the chain continues with its last task
+	future, _ = balancer.Submit(build(nil, errors.New("not cached")))
+	responses, err = future.Wait(context.Background())
+	if err != nil || responses[2].Data != "got stored loaded none" {
+		t.Fatalf("unexpected result : %v", err)
+	}
+	nested = responses[1].Nested
+	if !nested[0].Skipped || nested[1].Skipped || nested[2].Skipped || nested[1].Data != "loaded none" {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestBranchSkippedAndUnknown(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	build := func(name string) *Request {
+		return BuildRequests(context.Background(), NewFutureTask(valueTask("user", nil))).
+			Branch(func(*Response) string { return name },
+				NewBranch("a", passBridge, NewFutureTask(echoTask("a ")))).
+			FollowedBy(passBridge, NewFutureTask(echoTask("got ")))
+	}
+
+	// No branch is chosen, the data of the previous task is passed on
+	future, _ := balancer.Submit(build(""))
+	responses, err := future.Wait(context.Background())
+	if err != nil || !responses[1].Skipped || !responses[1].Nested[0].Skipped || responses[2].Data != "got user" {
+		t.Fatalf("unexpected result : %v", err)
+	}
+
+	future, _ = balancer.Submit(build("b"))
+	responses, _ = future.Wait(context.Background())
+	if len(responses) < 2 || responses[1].Error != ErrUnknownBranch {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestBranchValidation(t *testing.T) {
+	first := func() *Request {
+		return BuildRequests(context.Background(), NewFutureTask(valueTask("user", nil)))
+	}
+	invalid := map[string]*Request{
+		"needs a task before it": (&Request{Ctx: context.Background()}).
+			Branch(cacheBranch, NewBranch("hit", passBridge, NewFutureTask(Task1))),
+		"has no branches": first().Branch(cacheBranch),
+		"bridge to the task 0 of the branch \"hit\" is nil": first().
+			Branch(cacheBranch, NewBranch("hit", nil, NewFutureTask(Task1))),
+		"2 tasks and 1 bridges": first().Branch(cacheBranch,
+			&Branch{Name: "hit", Bridges: []Bridge{passBridge}, Tasks: []*FutureTask{NewFutureTask(Task1), NewFutureTask(Task2)}}),
+		"name \"hit\" is repeated": first().Branch(cacheBranch,
+			NewBranch("hit", passBridge, NewFutureTask(Task1)), NewBranch("hit", passBridge, NewFutureTask(Task2))),
+		"callback of the task \"\" is nil": first().Branch(cacheBranch, NewBranch("hit", passBridge, &FutureTask{})),
+	}
+	for message, request := range invalid {
+		if err := request.Validate(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s : unexpected error %v", message, err)
+		}
+	}
+}
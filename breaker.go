@@ -0,0 +1,323 @@
+package rio
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// This error is set on the response of a task, when its call is short circuited by an open circuit breaker
+var ErrCircuitOpen = errors.New("the circuit breaker is open")
+
+// The state of a circuit breaker
+type CircuitState int
+
+const (
+	// The calls go through and their outcomes are recorded
+	CircuitClosed CircuitState = iota
+	// The calls are short circuited with ErrCircuitOpen
+	CircuitOpen
+	// A limited number of trial calls go through, to decide whether to close or to open the breaker again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// The number of buckets the rolling window of a circuit breaker is split into
+const breakerBuckets = 10
+
+// The configuration of a circuit breaker. The zero values are replaced by the defaults, mentioned with the fields.
+type CircuitBreakerConfig struct {
+	// The failure rate, between 0 and 1, at or above which the breaker opens. Default is 0.5.
+	FailureRateThreshold float64
+
+	// A call taking longer than this is counted as a slow call, 0 disables the slow call check. A timed out call is
+	// always counted as a slow one.
+	SlowCallDuration time.Duration
+
+	// The slow call rate, between 0 and 1, at or above which the breaker opens. Default is 0.5.
+	SlowCallRateThreshold float64
+
+	// The rolling window, the rates are computed on. Default is 10 seconds.
+	Window time.Duration
+
+	// The minimum number of calls in the window, before the rates are evaluated. Default is 10.
+	MinimumCalls int
+
+	// How long the breaker stays open, before letting the trial calls through. Default is 10 seconds.
+	OpenDuration time.Duration
+
+	// The number of trial calls in the half open state. Default is 3.
+	HalfOpenCalls int
+}
+
+// The stats of a circuit breaker, the counts are of the current rolling window
+type BreakerStats struct {
+	Name         string  `json:"name"`
+	State        string  `json:"state"`
+	Calls        int     `json:"calls"`
+	Failures     int     `json:"failures"`
+	SlowCalls    int     `json:"slow_calls"`
+	FailureRate  float64 `json:"failure_rate"`
+	SlowCallRate float64 `json:"slow_call_rate"`
+}
+
+// CircuitBreaker protects a backend, which is called by the tasks with the same name. When the backend fails or slows
+// down beyond the thresholds, the breaker opens and the tasks fail fast, without calling it.
+type CircuitBreaker struct {
+	name   string
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    CircuitState
+	openedAt time.Time
+	buckets  [breakerBuckets]breakerBucket
+
+	// The generation is changed on every state transition, so that the outcome of a call started in an earlier
+	// state is not counted in the current one
+	generation uint64
+
+	// The trial calls of the half open state
+	trials       int
+	trialResults breakerBucket
+}
+
+type breakerBucket struct {
+	start    time.Time
+	calls    int
+	failures int
+	slow     int
+}
+
+// Use this method to create a circuit breaker. Add it to a balancer with AddCircuitBreaker to use it.
+func NewCircuitBreaker(name string, config CircuitBreakerConfig) *CircuitBreaker {
+	if config.FailureRateThreshold <= 0 {
+		config.FailureRateThreshold = 0.5
+	}
+	if config.SlowCallRateThreshold <= 0 {
+		config.SlowCallRateThreshold = 0.5
+	}
+	if config.Window < breakerBuckets {
+		config.Window = 10 * time.Second
+	}
+	if config.MinimumCalls <= 0 {
+		config.MinimumCalls = 10
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = 10 * time.Second
+	}
+	if config.HalfOpenCalls <= 0 {
+		config.HalfOpenCalls = 3
+	}
+	return &CircuitBreaker{name: name, config: config}
+}
+
+// The name of the tasks, this breaker protects
+func (c *CircuitBreaker) Name() string {
+	return c.name
+}
+
+// The current state of the breaker
+func (c *CircuitBreaker) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh(time.Now())
+	return c.state
+}
+
+// The current stats of the breaker
+func (c *CircuitBreaker) Stats() *BreakerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.refresh(now)
+	totals := c.totals(now)
+	if c.state == CircuitHalfOpen {
+		totals = c.trialResults
+	}
+	failureRate, slowCallRate := totals.rates()
+	return &BreakerStats{
+		Name:         c.name,
+		State:        c.state.String(),
+		Calls:        totals.calls,
+		Failures:     totals.failures,
+		SlowCalls:    totals.slow,
+		FailureRate:  failureRate,
+		SlowCallRate: slowCallRate,
+	}
+}
+
+// Tells if a call can go through. The returned generation must be passed on to record or release the call.
+func (c *CircuitBreaker) allow() (uint64, bool) {
+	if c == nil {
+		return 0, true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh(time.Now())
+	switch c.state {
+	case CircuitOpen:
+		return c.generation, false
+	case CircuitHalfOpen:
+		if c.trials+c.trialResults.calls >= c.config.HalfOpenCalls {
+			return c.generation, false
+		}
+		c.trials++
+	}
+	return c.generation, true
+}
+
+// Records the outcome of an allowed call
+func (c *CircuitBreaker) record(generation uint64, duration time.Duration, failed bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	now := time.Now()
+	slow := c.config.SlowCallDuration > 0 && duration > c.config.SlowCallDuration
+
+	switch c.state {
+	case CircuitClosed:
+		c.bucket(now).add(failed, slow)
+		if totals := c.totals(now); totals.calls >= c.config.MinimumCalls && c.exceeds(totals) {
+			c.transition(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		c.trials--
+		c.trialResults.add(failed, slow)
+		if c.trialResults.calls >= c.config.HalfOpenCalls {
+			if c.exceeds(c.trialResults) {
+				c.transition(CircuitOpen, now)
+			} else {
+				c.transition(CircuitClosed, now)
+			}
+		}
+	}
+}
+
+// Releases an allowed call, which is abandoned without an outcome, like on a context cancellation
+func (c *CircuitBreaker) release(generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation && c.state == CircuitHalfOpen {
+		c.trials--
+	}
+}
+
+// Moves an open breaker to half open, once the open duration is over
+func (c *CircuitBreaker) refresh(now time.Time) {
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= c.config.OpenDuration {
+		c.transition(CircuitHalfOpen, now)
+	}
+}
+
+func (c *CircuitBreaker) transition(state CircuitState, now time.Time) {
+	c.state = state
+	c.generation++
+	c.trials = 0
+	c.trialResults = breakerBucket{}
+	c.buckets = [breakerBuckets]breakerBucket{}
+	if state == CircuitOpen {
+		c.openedAt = now
+	}
+}
+
+func (c *CircuitBreaker) exceeds(totals breakerBucket) bool {
+	failureRate, slowCallRate := totals.rates()
+	return failureRate >= c.config.FailureRateThreshold ||
+		(c.config.SlowCallDuration > 0 && slowCallRate >= c.config.SlowCallRateThreshold)
+}
+
+// The bucket of the rolling window for the time, it is reset when it belongs to an earlier round of the window
+func (c *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := c.config.Window / breakerBuckets
+	start := now.Truncate(width)
+	b := &c.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !b.start.Equal(start) {
+		*b = breakerBucket{start: start}
+	}
+	return b
+}
+
+// The sum of the buckets, which are still in the rolling window
+func (c *CircuitBreaker) totals(now time.Time) breakerBucket {
+	var totals breakerBucket
+	for _, b := range c.buckets {
+		if !b.start.IsZero() && now.Sub(b.start) < c.config.Window {
+			totals.calls += b.calls
+			totals.failures += b.failures
+			totals.slow += b.slow
+		}
+	}
+	return totals
+}
+
+func (b *breakerBucket) add(failed, slow bool) {
+	b.calls++
+	if failed {
+		b.failures++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+func (b breakerBucket) rates() (float64, float64) {
+	if b.calls == 0 {
+		return 0, 0
+	}
+	return float64(b.failures) / float64(b.calls), float64(b.slow) / float64(b.calls)
+}
+
+// Use this method to add a circuit breaker to the balancer. It protects all the tasks with the given name, an earlier
+// breaker with the same name is replaced.
+func (b *Balancer) AddCircuitBreaker(name string, config CircuitBreakerConfig) *CircuitBreaker {
+	breaker := NewCircuitBreaker(name, config)
+	b.policiesMu.Lock()
+	defer b.policiesMu.Unlock()
+	if b.breakers == nil {
+		b.breakers = make(map[string]*CircuitBreaker)
+	}
+	b.breakers[name] = breaker
+	return breaker
+}
+
+// Use this method to get the circuit breaker of the tasks with the given name, it is nil when there is none
+func (b *Balancer) CircuitBreaker(name string) *CircuitBreaker {
+	if name == "" {
+		return nil
+	}
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	return b.breakers[name]
+}
+
+// The stats of all the circuit breakers, sorted by name
+func (b *Balancer) breakerStats() []*BreakerStats {
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	stats := make([]*BreakerStats, 0, len(b.breakers))
+	for _, breaker := range b.breakers {
+		stats = append(stats, breaker.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
@@ -0,0 +1,110 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breaker := NewCircuitBreaker("backend", CircuitBreakerConfig{
+		MinimumCalls:  4,
+		OpenDuration:  50 * time.Millisecond,
+		HalfOpenCalls: 2,
+	})
+
+	for _, failed := range []bool{false, true, false, true} {
+		generation, allowed := breaker.allow()
+		if !allowed {
+			t.Fatal("The closed breaker should allow the calls")
+		}
+		breaker.record(generation, time.Millisecond, failed)
+	}
+	if breaker.State() != CircuitOpen {
+		t.Fatal("The breaker should open at 50% failures")
+	}
+	if _, allowed := breaker.allow(); allowed {
+		t.Fail()
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	first, allowed1 := breaker.allow()
+	second, allowed2 := breaker.allow()
+	if _, allowed3 := breaker.allow(); !allowed1 || !allowed2 || allowed3 || breaker.State() != CircuitHalfOpen {
+		t.Fatal("The half open breaker should allow only the trial calls")
+	}
+
+	// An abandoned trial call frees its slot
+	breaker.release(second)
+	second, _ = breaker.allow()
+
+	breaker.record(first, time.Millisecond, false)
+	breaker.record(second, time.Millisecond, false)
+	if breaker.State() != CircuitClosed {
+		t.Fail()
+	}
+
+	// The outcome of a call from an earlier state is ignored
+	breaker.record(first, time.Millisecond, true)
+	if stats := breaker.Stats(); stats.Calls != 0 || stats.State != "closed" {
+		t.Fail()
+	}
+}
+
+func TestCircuitBreakerWithSlowCalls(t *testing.T) {
+	breaker := NewCircuitBreaker("backend", CircuitBreakerConfig{
+		MinimumCalls:          2,
+		SlowCallDuration:      10 * time.Millisecond,
+		SlowCallRateThreshold: 1,
+	})
+	generation, _ := breaker.allow()
+	breaker.record(generation, 20*time.Millisecond, false)
+	generation, _ = breaker.allow()
+	breaker.record(generation, 5*time.Millisecond, false)
+	if breaker.State() != CircuitClosed {
+		t.Fail()
+	}
+	generation, _ = breaker.allow()
+	breaker.record(generation, 20*time.Millisecond, false)
+	if stats := breaker.Stats(); stats.State != "closed" || stats.SlowCalls != 2 || stats.Calls != 3 {
+		t.Fail()
+	}
+}
+
+func TestCircuitBreakerInBalancer(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+	balancer.AddCircuitBreaker("backend", CircuitBreakerConfig{MinimumCalls: 2, OpenDuration: time.Minute})
+
+	var calls int32
+	failing := func(*BridgeConnection) *FutureTaskResponse {
+		atomic.AddInt32(&calls, 1)
+		return &FutureTaskResponse{ResponseCode: 500, Error: errors.New("backend down")}
+	}
+
+	// The breaker opens after the second attempt, so the last retry is short circuited
+	future, _ := balancer.Submit(BuildRequests(context.Background(),
+		NewNamedFutureTask("backend", failing).WithSecondTimeout(10).WithRetry(3)))
+	responses, _ := future.Wait(context.Background())
+	if responses[0].Error != ErrCircuitOpen || atomic.LoadInt32(&calls) != 2 {
+		t.Fail()
+	}
+
+	// The open breaker leads to the fallback, without calling the backend
+	future, _ = balancer.Submit(BuildRequests(context.Background(),
+		NewNamedFutureTask("backend", failing).WithSecondTimeout(10).WithDefault("Cached")))
+	responses, _ = future.Wait(context.Background())
+	if !responses[0].Fallback || atomic.LoadInt32(&calls) != 2 {
+		t.Fail()
+	}
+
+	stats, _ := balancer.Stats()
+	if len(stats.CircuitBreakers) != 1 || stats.CircuitBreakers[0].State != "open" {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
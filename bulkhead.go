@@ -0,0 +1,132 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// This error is set on the response of a task, when its bulkhead has no free slot within the wait time
+var ErrBulkheadFull = errors.New("the bulkhead is full")
+
+// The stats of a bulkhead
+type BulkheadStats struct {
+	Name          string `json:"name"`
+	MaxConcurrent int    `json:"max_concurrent"`
+	InFlight      int    `json:"in_flight"`
+	Waiting       int    `json:"waiting"`
+	Rejected      int    `json:"rejected"`
+}
+
+// Bulkhead limits the number of the concurrent calls to a backend, across all the workers of a balancer. So a slow
+// backend can occupy only its share of the workers, while the other tasks keep flowing.
+type Bulkhead struct {
+	name    string
+	slots   chan struct{}
+	maxWait time.Duration
+
+	// These are accessed atomically
+	waiting  int32
+	rejected int32
+}
+
+// Use this method to create a bulkhead, which allows maxConcurrent calls at a time. An excess call waits for a free
+// slot up to maxWait, a maxWait of 0 fails it immediately. Add it to a balancer with AddBulkhead to use it.
+func NewBulkhead(name string, maxConcurrent int, maxWait time.Duration) *Bulkhead {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Bulkhead{name: name, slots: make(chan struct{}, maxConcurrent), maxWait: maxWait}
+}
+
+// The name of the bulkhead, the tasks refer to it by this name
+func (b *Bulkhead) Name() string {
+	return b.name
+}
+
+// The current stats of the bulkhead
+func (b *Bulkhead) Stats() *BulkheadStats {
+	return &BulkheadStats{
+		Name:          b.name,
+		MaxConcurrent: cap(b.slots),
+		InFlight:      len(b.slots),
+		Waiting:       int(atomic.LoadInt32(&b.waiting)),
+		Rejected:      int(atomic.LoadInt32(&b.rejected)),
+	}
+}
+
+// Takes a slot, waiting for it up to the max wait. It returns ErrBulkheadFull when no slot is free in time, or the
+// context error when the context is done first.
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if b.maxWait <= 0 {
+		atomic.AddInt32(&b.rejected, 1)
+		return ErrBulkheadFull
+	}
+
+	atomic.AddInt32(&b.waiting, 1)
+	defer atomic.AddInt32(&b.waiting, -1)
+	timer := time.NewTimer(b.maxWait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		atomic.AddInt32(&b.rejected, 1)
+		return ErrBulkheadFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Frees a slot taken by acquire
+func (b *Bulkhead) release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
+
+// Use this method to add a bulkhead to the balancer. The tasks tagged with the bulkhead name, see
+// FutureTask.WithBulkhead, share its slots. An earlier bulkhead with the same name is replaced.
+func (b *Balancer) AddBulkhead(name string, maxConcurrent int, maxWait time.Duration) *Bulkhead {
+	bulkhead := NewBulkhead(name, maxConcurrent, maxWait)
+	b.policiesMu.Lock()
+	defer b.policiesMu.Unlock()
+	if b.bulkheads == nil {
+		b.bulkheads = make(map[string]*Bulkhead)
+	}
+	b.bulkheads[name] = bulkhead
+	return bulkhead
+}
+
+// Use this method to get the bulkhead with the given name, it is nil when there is none
+func (b *Balancer) Bulkhead(name string) *Bulkhead {
+	if name == "" {
+		return nil
+	}
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	return b.bulkheads[name]
+}
+
+// The stats of all the bulkheads, sorted by name
+func (b *Balancer) bulkheadStats() []*BulkheadStats {
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	stats := make([]*BulkheadStats, 0, len(b.bulkheads))
+	for _, bulkhead := range b.bulkheads {
+		stats = append(stats, bulkhead.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
@@ -0,0 +1,79 @@
+package rio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBulkheadInBalancer(t *testing.T) {
+	balancer := GetBalancer(3, 1)
+	balancer.AddBulkhead("slow", 1, 0)
+
+	release := make(chan bool)
+	first, _ := balancer.Submit(BuildRequests(context.Background(),
+		NewFutureTask(blockingTask(release)).WithSecondTimeout(10).WithBulkhead("slow")))
+
+	// Wait for the first request to take the only slot
+	for balancer.Bulkhead("slow").Stats().InFlight != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	second, _ := balancer.Submit(BuildRequests(context.Background(),
+		NewFutureTask(blockingTask(release)).WithSecondTimeout(10).WithBulkhead("slow").WithRetry(2)))
+	responses, err := second.Wait(context.Background())
+	if err != nil || responses[0].Error != ErrBulkheadFull {
+		t.Fail()
+	}
+
+	// The other tasks keep flowing
+	other, _ := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(Task4).WithSecondTimeout(10)))
+	if responses, err := other.Wait(context.Background()); err != nil || responses[0].Data.(string) != "Response 4" {
+		t.Fail()
+	}
+
+	close(release)
+	if responses, err := first.Wait(context.Background()); err != nil || responses[0].Data.(string) != "Released" {
+		t.Fail()
+	}
+
+	// The slot is released after the response is sent, so wait for it
+	deadline := time.Now().Add(time.Second)
+	for balancer.Bulkhead("slow").Stats().InFlight != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stats, _ := balancer.Stats()
+	if len(stats.Bulkheads) != 1 || stats.Bulkheads[0].Rejected != 1 || stats.Bulkheads[0].InFlight != 0 {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestBulkheadWait(t *testing.T) {
+	bulkhead := NewBulkhead("backend", 1, 200*time.Millisecond)
+	if bulkhead.acquire(context.Background()) != nil {
+		t.Fail()
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		bulkhead.release()
+	}()
+	if bulkhead.acquire(context.Background()) != nil {
+		t.Fail()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if bulkhead.acquire(ctx) != context.Canceled {
+		t.Fail()
+	}
+
+	bulkhead = NewBulkhead("backend", 1, 20*time.Millisecond)
+	bulkhead.acquire(context.Background())
+	if bulkhead.acquire(context.Background()) != ErrBulkheadFull || bulkhead.Stats().Rejected != 1 {
+		t.Fail()
+	}
+}
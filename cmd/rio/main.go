@@ -0,0 +1,425 @@
+// Command rio validates, renders and runs the declarative pipeline definitions and queries the admin endpoint of a
+// running balancer, to debug the orchestration without writing a Go harness.
+//
+//	rio validate <file>...
+//	rio render [-format dot|mermaid] <file>...
+//	rio run [-input value]... [-exec callback=command]... [-timeout 5s] [-graph dot|mermaid] [-v] <file>...
+//	rio stats [-addr url]
+//	rio inflight [-addr url]
+//	rio cancel [-addr url] <id>
+//
+// The run command builds all the files in order, so that the later ones can nest the earlier ones as pipeline tasks,
+// and runs the last one. The callbacks are stubs, which echo their name and their data, unless they are mapped to a
+// shell command by -exec, which runs as an exec task. The command gets the data of the bridge on its stdin, a value per
+// line, and the lines of its stdout are the data of the response. All the bridges pass the data through.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/susamn/rio"
+)
+
+const usage = `usage: rio <command> [flags] [args]
+
+commands:
+  validate <file>...   checks the pipeline definitions
+  render <file>...     prints the pipeline definitions as DOT or Mermaid graphs
+  run <file>...        runs the last pipeline with stub or shell command callbacks
+  stats                prints the stats of a running balancer
+  inflight             prints the in-flight requests of a running balancer
+  cancel <id>          cancels a request in a running balancer
+
+run 'rio <command> -h' for the flags of a command
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Runs the command line and returns the exit code, 0 on success, 1 on a failure and 2 on a usage error
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	commands := map[string]func([]string, io.Writer, io.Writer) int{
+		"validate": validate,
+		"render":   render,
+		"run":      runPipeline,
+		"stats":    adminGet("stats"),
+		"inflight": adminGet("inflight"),
+		"cancel":   cancelRequest,
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "rio: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	return command(args[1:], stdout, stderr)
+}
+
+func validate(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("validate <file>...", stderr)
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags)
+	}
+	code := 0
+	for _, path := range flags.Args() {
+		definition, err := loadDefinition(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(stdout, "%s : pipeline %s with %d tasks is valid\n", path, definition.Name, len(definition.Tasks))
+	}
+	return code
+}
+
+func render(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("render [-format dot|mermaid] <file>...", stderr)
+	format := flags.String("format", "dot", "the graph format, dot or mermaid")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || !validFormat(*format) {
+		return usageError(flags)
+	}
+	for _, path := range flags.Args() {
+		definition, err := loadDefinition(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if *format == "mermaid" {
+			fmt.Fprint(stdout, definition.Mermaid())
+		} else {
+			fmt.Fprint(stdout, definition.DOT())
+		}
+	}
+	return 0
+}
+
+func runPipeline(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("run [flags] <file>...", stderr)
+	var inputs, commands listFlag
+	flags.Var(&inputs, "input", "an input of the first task, can be repeated")
+	flags.Var(&commands, "exec", "maps a callback to a shell command, like GetUser='curl -s ...', can be repeated")
+	timeout := flags.Duration("timeout", 30*time.Second, "the timeout of the request")
+	format := flags.String("graph", "", "prints the request with its results as a dot or mermaid graph")
+	verbose := flags.Bool("v", false, "prints the logs of the balancer")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || (*format != "" && !validFormat(*format)) {
+		return usageError(flags)
+	}
+	shell := make(map[string]string)
+	for _, mapping := range commands {
+		name := strings.SplitN(mapping, "=", 2)
+		if len(name) != 2 || name[0] == "" {
+			fmt.Fprintf(stderr, "rio: the exec flag %q is not like callback=command\n", mapping)
+			return 2
+		}
+		shell[name[0]] = name[1]
+	}
+
+	registry := rio.NewRegistry()
+	var pipeline *rio.Pipeline
+	for _, path := range flags.Args() {
+		definition, err := loadDefinition(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if err := register(registry, definition, shell); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if pipeline, err = registry.Build(definition); err != nil {
+			fmt.Fprintf(stderr, "%s : %v\n", path, err)
+			return 1
+		}
+		registry.RegisterPipeline(pipeline)
+	}
+
+	logs := ioutil.Discard
+	if *verbose {
+		logs = stderr
+	}
+	balancer, err := rio.New(rio.WithWorkers(1), rio.WithLogger(log.New(logs, "", log.LstdFlags)))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer func() {
+		closed := make(chan bool)
+		balancer.Close(closed)
+		<-closed
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var input []interface{}
+	for _, value := range inputs {
+		input = append(input, value)
+	}
+	request := pipeline.NewRequest(ctx, input...)
+	future, err := balancer.Submit(request)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	responses, err := future.Wait(context.Background())
+
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TASK\tCODE\tLATENCY\tRESULT")
+	printResponses(table, "", request.Tasks, responses)
+	table.Flush()
+	switch *format {
+	case "dot":
+		fmt.Fprint(stdout, request.DOT())
+	case "mermaid":
+		fmt.Fprint(stdout, request.Mermaid())
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "rio: the request has failed : %v\n", err)
+		return 1
+	}
+	for i, response := range responses {
+		if response.Error != nil {
+			fmt.Fprintf(stderr, "rio: the task %d has failed : %v\n", i, response.Error)
+			return 1
+		}
+	}
+	return 0
+}
+
+// Registers the stubs for all the callbacks and the bridges of the definition, which are not registered yet, the
+// callbacks mapped to a shell command are registered as exec tasks
+func register(registry *rio.Registry, definition *rio.PipelineDefinition, shell map[string]string) error {
+	for _, task := range definition.Tasks {
+		name := task.Callback
+		if name == "" {
+			name = task.Name
+		}
+		if task.Pipeline != "" || registry.Callback(name) != nil || registry.Task(name) != nil {
+			continue
+		}
+		command, ok := shell[name]
+		if !ok {
+			registry.RegisterCallback(name, stubCallback(name))
+			continue
+		}
+		execTask, err := rio.NewExecTask(name, rio.Command{
+			Path:   "sh",
+			Args:   []string{"-c", command},
+			Stdin:  "{{range .}}{{.}}\n{{end}}",
+			Output: rio.LinesOutput,
+		})
+		if err != nil {
+			return err
+		}
+		registry.RegisterTask(name, execTask)
+	}
+	for _, edge := range definition.Edges {
+		if edge.Bridge != "" && registry.Bridge(edge.Bridge) == nil {
+			registry.RegisterBridge(edge.Bridge, func(data interface{}) *rio.BridgeConnection {
+				return &rio.BridgeConnection{Data: []interface{}{data}}
+			})
+		}
+		if edge.HistoryBridge != "" && registry.HistoryBridge(edge.HistoryBridge) == nil {
+			registry.RegisterHistoryBridge(edge.HistoryBridge, func(h *rio.History) *rio.BridgeConnection {
+				var data []interface{}
+				for _, response := range h.Responses {
+					data = append(data, response.Data)
+				}
+				return &rio.BridgeConnection{Data: data}
+			})
+		}
+	}
+	return nil
+}
+
+// The stub callback responds with its name and its data, like GetUser(42)
+func stubCallback(name string) rio.Callback {
+	return func(bconn *rio.BridgeConnection) *rio.FutureTaskResponse {
+		return &rio.FutureTaskResponse{ResponseCode: 200, Data: fmt.Sprintf("%s(%s)", name, strings.Join(values(bconn), ", "))}
+	}
+}
+
+// The values of the bridge data as strings, the lines of the shell commands are the values too
+func values(bconn *rio.BridgeConnection) []string {
+	var data []string
+	if bconn != nil {
+		for _, value := range bconn.Data {
+			if lines, ok := value.([]string); ok {
+				data = append(data, lines...)
+			} else {
+				data = append(data, fmt.Sprint(value))
+			}
+		}
+	}
+	return data
+}
+
+// Prints a row per response, the nested responses of the pipeline tasks are indented under them
+func printResponses(w io.Writer, indent string, tasks []*rio.FutureTask, responses []*rio.Response) {
+	for i, response := range responses {
+		name := fmt.Sprintf("task %d", i)
+		var nested []*rio.FutureTask
+		if i < len(tasks) {
+			if tasks[i].Name != "" {
+				name = tasks[i].Name
+			}
+			if tasks[i].Pipeline != nil {
+				nested = tasks[i].Pipeline.NewRequest(context.Background()).Tasks
+			}
+		}
+		result := fmt.Sprint(response.Data)
+		if lines, ok := response.Data.([]string); ok {
+			result = strings.Join(lines, " | ")
+		}
+		switch {
+		case response.Skipped:
+			result = "skipped"
+		case response.Error != nil:
+			result = "error: " + response.Error.Error()
+		case response.Fallback:
+			result += " (fallback)"
+		}
+		fmt.Fprintf(w, "%s%s\t%d\t%v\t%s\n", indent, name, response.ResponseCode, response.ResponseTime.Round(time.Microsecond), result)
+		printResponses(w, indent+"  ", nested, response.Nested)
+	}
+}
+
+func adminGet(endpoint string) func([]string, io.Writer, io.Writer) int {
+	return func(args []string, stdout, stderr io.Writer) int {
+		flags := newFlagSet(endpoint, stderr)
+		addr := addrFlag(flags)
+		if flags.Parse(args) != nil {
+			return 2
+		}
+		if flags.NArg() != 0 {
+			return usageError(flags)
+		}
+		return admin(http.MethodGet, *addr+"/"+endpoint, stdout, stderr)
+	}
+}
+
+func cancelRequest(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("cancel <id>", stderr)
+	addr := addrFlag(flags)
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return usageError(flags)
+	}
+	return admin(http.MethodPost, *addr+"/cancel?id="+url.QueryEscape(flags.Arg(0)), stdout, stderr)
+}
+
+// Calls the admin endpoint and prints its indented JSON result, or its error
+func admin(method, endpoint string, stdout, stderr io.Writer) int {
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var failure struct{ Error string }
+		if json.Unmarshal(body, &failure) != nil || failure.Error == "" {
+			failure.Error = strings.TrimSpace(string(body))
+		}
+		fmt.Fprintf(stderr, "rio: %s : %s\n", res.Status, failure.Error)
+		return 1
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") != nil {
+		stdout.Write(body)
+		return 0
+	}
+	fmt.Fprint(stdout, indented.String())
+	return 0
+}
+
+func loadDefinition(path string) (*rio.PipelineDefinition, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	definition, err := rio.ParsePipelineDefinition(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %v", path, err)
+	}
+	return definition, nil
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: rio %s\n", name)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func addrFlag(flags *flag.FlagSet) *string {
+	return flags.String("addr", "http://localhost:8080", "the url, the admin handler is served at, like http://host:8080/admin/rio")
+}
+
+func usageError(flags *flag.FlagSet) int {
+	flags.Usage()
+	return 2
+}
+
+func validFormat(format string) bool {
+	return format == "dot" || format == "mermaid"
+}
+
+// The flag, which can be repeated
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+	if value == "" {
+		return errors.New("the value is empty")
+	}
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/susamn/rio"
+)
+
+const testPipeline = `
+name: Profile
+tasks:
+  - name: GetUser
+    timeout: 1s
+  - name: Shout
+edges:
+  - from: GetUser
+    to: Shout
+    bridge: pass
+`
+
+const testPage = `
+name: Page
+tasks:
+  - name: Profile
+    pipeline: Profile
+  - name: Render
+edges:
+  - from: Profile
+    to: Render
+    history_bridge: all
+`
+
+func writeFiles(t *testing.T, contents ...string) ([]string, func()) {
+	dir, err := ioutil.TempDir("", "rio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for i, content := range contents {
+		path := filepath.Join(dir, string(rune('a'+i))+".yaml")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, func() { os.RemoveAll(dir) }
+}
+
+func runCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestValidateAndRender(t *testing.T) {
+	paths, remove := writeFiles(t, testPipeline, "tasks:\n  - name: A\n    timeout: soon")
+	defer remove()
+
+	code, stdout, stderr := runCommand("validate", paths[0], paths[1])
+	if code != 1 || !strings.Contains(stdout, "pipeline Profile with 2 tasks is valid") ||
+		!strings.Contains(stderr, "tasks[0].timeout") {
+		t.Errorf("unexpected result %d : %s %s", code, stdout, stderr)
+	}
+
+	code, stdout, _ = runCommand("render", "-format", "mermaid", paths[0])
+	if code != 0 || !strings.Contains(stdout, `t0 -->|"pass"| t1`) {
+		t.Errorf("unexpected result %d : %s", code, stdout)
+	}
+	if code, _, _ = runCommand("render", "-format", "svg", paths[0]); code != 2 {
+		t.Errorf("unexpected code %d", code)
+	}
+	if code, _, _ = runCommand("unknown"); code != 2 {
+		t.Errorf("unexpected code %d", code)
+	}
+}
+
+func TestRunWithStubsAndShell(t *testing.T) {
+	paths, remove := writeFiles(t, testPipeline, testPage)
+	defer remove()
+
+	code, stdout, stderr := runCommand("run", "-input", "42", "-exec", "Shout=tr a-z A-Z", "-graph", "dot", paths[0], paths[1])
+	if code != 0 {
+		t.Fatalf("unexpected result %d : %s", code, stderr)
+	}
+	for _, expected := range []string{"  GetUser", "GetUser(42)", "  Shout", "GETUSER(42)", "Render(GETUSER(42))", `fillcolor="#d4edda"`} {
+		if !strings.Contains(stdout, expected) {
+			t.Errorf("%q is not in\n%s", expected, stdout)
+		}
+	}
+
+	code, _, stderr = runCommand("run", "-exec", "Shout=echo broken >&2; exit 3", paths[0])
+	if code != 1 || !strings.Contains(stderr, "broken") {
+		t.Errorf("unexpected result %d : %s", code, stderr)
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	balancer := rio.GetBalancer(1, 1)
+	server := httptest.NewServer(rio.NewAdminHandler(balancer))
+	defer server.Close()
+
+	code, stdout, _ := runCommand("stats", "-addr", server.URL)
+	if code != 0 || !strings.Contains(stdout, "\n  ") {
+		t.Errorf("unexpected result %d : %s", code, stdout)
+	}
+	if code, stdout, _ = runCommand("inflight", "-addr", server.URL); code != 0 {
+		t.Errorf("unexpected result %d : %s", code, stdout)
+	}
+	code, _, stderr := runCommand("cancel", "-addr", server.URL, "missing")
+	if code != 1 || !strings.Contains(stderr, "404 Not Found : no queued or in-flight request") {
+		t.Errorf("unexpected result %d : %s", code, stderr)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
@@ -0,0 +1,209 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This error is returned by PostJob, when the adaptive concurrency limit of the balancer is reached
+var ErrConcurrencyLimit = errors.New("the concurrency limit is reached, try again later")
+
+// ConcurrencyLimiter decides the number of the requests, the balancer allows to be in-flight. The limit is adjusted
+// from the latency and the outcome of the completed requests. The implementations must be safe for concurrent use.
+type ConcurrencyLimiter interface {
+	// The current limit
+	Limit() int
+
+	// Called with the number of the in-flight requests, the latency and the outcome of every completed request
+	Update(inFlight int, latency time.Duration, failed bool)
+}
+
+// The configuration of the AIMD limiter. The zero values are replaced by the defaults, mentioned with the fields.
+type AIMDConfig struct {
+	// The limits, defaults are 10, 1 and 1000
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// A request slower than this is handled like a failed one, 0 disables the latency check
+	LatencyThreshold time.Duration
+
+	// The limit is multiplied by this on a failure, default is 0.9
+	BackoffRatio float64
+}
+
+// The configuration of the gradient limiter. The zero values are replaced by the defaults, mentioned with the fields.
+type GradientConfig struct {
+	// The limits, defaults are 10, 1 and 1000
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// How much the latency can grow above the long term average, before the limit is reduced, default is 1.5
+	Tolerance float64
+
+	// How fast the limit moves to the newly computed one, between 0 and 1, default is 0.2
+	Smoothing float64
+
+	// The number of the requests, the long term latency average is computed on, default is 100
+	LongWindow int
+
+	// The limit is multiplied by this on a failure, default is 0.9
+	BackoffRatio float64
+}
+
+// The AIMD limiter grows the limit by one for every successful request, while the limit is being used, and cuts it
+// by the backoff ratio on every failed or slow request
+type AIMDLimiter struct {
+	config AIMDConfig
+
+	mu    sync.Mutex
+	limit float64
+}
+
+// Use this method to create an AIMD limiter, set it on a balancer with SetConcurrencyLimiter to use it
+func NewAIMDLimiter(config AIMDConfig) *AIMDLimiter {
+	config.InitialLimit, config.MinLimit, config.MaxLimit = limitDefaults(config.InitialLimit, config.MinLimit, config.MaxLimit)
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		config.BackoffRatio = 0.9
+	}
+	return &AIMDLimiter{config: config, limit: float64(config.InitialLimit)}
+}
+
+func (l *AIMDLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func (l *AIMDLimiter) Update(inFlight int, latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if failed || (l.config.LatencyThreshold > 0 && latency > l.config.LatencyThreshold) {
+		l.limit = math.Max(float64(l.config.MinLimit), math.Floor(l.limit*l.config.BackoffRatio))
+	} else if float64(inFlight)*2 >= l.limit {
+		l.limit = math.Min(float64(l.config.MaxLimit), l.limit+1)
+	}
+}
+
+// The gradient limiter compares the latency of every request with the long term average. While the latency stays
+// within the tolerance, the limit grows, when it goes beyond, the limit shrinks in proportion. A failed request cuts
+// the limit by the backoff ratio.
+type GradientLimiter struct {
+	config GradientConfig
+
+	mu      sync.Mutex
+	limit   float64
+	longRTT float64
+}
+
+// Use this method to create a gradient limiter, set it on a balancer with SetConcurrencyLimiter to use it
+func NewGradientLimiter(config GradientConfig) *GradientLimiter {
+	config.InitialLimit, config.MinLimit, config.MaxLimit = limitDefaults(config.InitialLimit, config.MinLimit, config.MaxLimit)
+	if config.Tolerance < 1 {
+		config.Tolerance = 1.5
+	}
+	if config.Smoothing <= 0 || config.Smoothing > 1 {
+		config.Smoothing = 0.2
+	}
+	if config.LongWindow <= 0 {
+		config.LongWindow = 100
+	}
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		config.BackoffRatio = 0.9
+	}
+	return &GradientLimiter{config: config, limit: float64(config.InitialLimit)}
+}
+
+func (l *GradientLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func (l *GradientLimiter) Update(inFlight int, latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if failed {
+		l.limit = math.Max(float64(l.config.MinLimit), math.Floor(l.limit*l.config.BackoffRatio))
+		return
+	}
+
+	rtt := float64(latency)
+	if rtt <= 0 {
+		return
+	}
+	if l.longRTT == 0 {
+		l.longRTT = rtt
+	} else {
+		l.longRTT += (rtt - l.longRTT) / float64(l.config.LongWindow)
+	}
+
+	// The limit is not grown, when it is not being used
+	if float64(inFlight)*2 < l.limit {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.config.Tolerance*l.longRTT/rtt))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	newLimit = l.limit*(1-l.config.Smoothing) + newLimit*l.config.Smoothing
+	l.limit = math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), newLimit))
+}
+
+func limitDefaults(initial, min, max int) (int, int, int) {
+	if min < 1 {
+		min = 1
+	}
+	if max <= 0 {
+		max = 1000
+	}
+	if max < min {
+		max = min
+	}
+	if initial <= 0 {
+		initial = 10
+	}
+	if initial < min {
+		initial = min
+	}
+	if initial > max {
+		initial = max
+	}
+	return initial, min, max
+}
+
+// Use this method to set an adaptive concurrency limiter on the balancer. When the posted requests, which are not
+// completed yet, reach its limit, PostJob returns ErrConcurrencyLimit. Use nil to remove the limiter.
+func (b *Balancer) SetConcurrencyLimiter(limiter ConcurrencyLimiter) {
+	b.policiesMu.Lock()
+	defer b.policiesMu.Unlock()
+	b.limiter = limiter
+}
+
+func (b *Balancer) concurrencyLimiter() ConcurrencyLimiter {
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	return b.limiter
+}
+
+// Feeds the concurrency limiter with a processed request. The latency is the time spent in the tasks, and the
+// request is failed, when it is stopped, other than by a cancellation, or any of its tasks failed.
+func (b *Balancer) observe(r *Request, err error) {
+	limiter := b.concurrencyLimiter()
+	if limiter == nil {
+		return
+	}
+	var latency time.Duration
+	failed := err != nil && err != context.Canceled
+	for _, response := range r.Responses {
+		if response.ResponseTime > 0 {
+			latency += response.ResponseTime
+		}
+		failed = failed || response.Error != nil
+	}
+	limiter.Update(int(atomic.LoadInt64(&b.admitted)), latency, failed)
+}
@@ -0,0 +1,91 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAIMDLimiter(t *testing.T) {
+	limiter := NewAIMDLimiter(AIMDConfig{InitialLimit: 10, MaxLimit: 11, LatencyThreshold: 100 * time.Millisecond})
+
+	// The limit grows only while it is being used
+	limiter.Update(2, time.Millisecond, false)
+	if limiter.Limit() != 10 {
+		t.Fail()
+	}
+	limiter.Update(5, time.Millisecond, false)
+	limiter.Update(5, time.Millisecond, false)
+	if limiter.Limit() != 11 {
+		t.Fail()
+	}
+
+	limiter.Update(5, time.Millisecond, true)
+	if limiter.Limit() != 9 {
+		t.Fail()
+	}
+	limiter.Update(5, time.Second, false)
+	if limiter.Limit() != 8 {
+		t.Fail()
+	}
+}
+
+func TestGradientLimiter(t *testing.T) {
+	limiter := NewGradientLimiter(GradientConfig{InitialLimit: 20, MinLimit: 5})
+
+	for i := 0; i < 50; i++ {
+		limiter.Update(20, 10*time.Millisecond, false)
+	}
+	grown := limiter.Limit()
+	if grown <= 20 {
+		t.Fatalf("The limit should grow while the latency is steady : %d", grown)
+	}
+
+	for i := 0; i < 50; i++ {
+		limiter.Update(grown, 100*time.Millisecond, false)
+	}
+	if limiter.Limit() >= grown {
+		t.Fatalf("The limit should shrink when the latency goes up : %d", limiter.Limit())
+	}
+
+	for i := 0; i < 100; i++ {
+		limiter.Update(grown, 10*time.Millisecond, true)
+	}
+	if limiter.Limit() != 5 {
+		t.Fail()
+	}
+}
+
+func TestConcurrencyLimiterInBalancer(t *testing.T) {
+	balancer := GetBalancer(2, 2)
+	limiter := NewAIMDLimiter(AIMDConfig{InitialLimit: 1, MaxLimit: 1})
+	balancer.SetConcurrencyLimiter(limiter)
+
+	release := make(chan bool)
+	first, _ := balancer.Submit(BuildRequests(context.Background(),
+		NewFutureTask(blockingTask(release)).WithSecondTimeout(10)))
+	if _, err := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(Task4).WithSecondTimeout(10))); err != ErrConcurrencyLimit {
+		t.Fail()
+	}
+	stats, _ := balancer.Stats()
+	if stats.ConcurrencyLimit != 1 {
+		t.Fail()
+	}
+	close(release)
+	first.Wait(context.Background())
+
+	failing := func(*BridgeConnection) *FutureTaskResponse {
+		return &FutureTaskResponse{Error: errors.New("failed")}
+	}
+	balancer.SetConcurrencyLimiter(NewAIMDLimiter(AIMDConfig{InitialLimit: 10}))
+	future, _ := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(failing).WithSecondTimeout(10)))
+	future.Wait(context.Background())
+	if stats, _ := balancer.Stats(); stats.ConcurrencyLimit != 9 {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
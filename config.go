@@ -0,0 +1,700 @@
+package rio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of a balancer, which can be loaded from a JSON or a YAML file and from the environment
+// variables, see LoadConfig. A zero value leaves the setting as it is, the default of New or the current one on a
+// Reload. The durations are written like 100ms or 2s in the files.
+//
+//	workers: 10
+//	queue_size: 1
+//	task_timeout: 500ms
+//	request_timeout: 2s
+//	admission_limit: 100
+//	retry:
+//	  max_retries: 2
+//	  backoff: 50ms
+//	  multiplier: 2
+//	  max_backoff: 1s
+//	tasks:
+//	  GetUser:
+//	    timeout: 200ms
+//	    retries: 1
+//	    breaker:
+//	      failure_rate_threshold: 0.5
+//	      open_duration: 30s
+//	    rate_limit:
+//	      rate: 100
+//	      burst: 10
+//	      wait: true
+//	tenants:
+//	  acme:
+//	    rate: 50
+type Config struct {
+	Workers        int
+	QueueSize      int
+	TaskTimeout    time.Duration
+	RequestTimeout time.Duration
+	AdmissionLimit int
+
+	// The retry policy, it is left as it is when nil
+	Retry *RetryPolicy
+
+	// The settings of the tasks by their name
+	Tasks map[string]*TaskConfig
+
+	// The rate limits of the tenants by their name
+	Tenants map[string]*RateLimit
+}
+
+// The settings of the tasks with the same name. The timeout and the retries override the ones set on the tasks, so
+// that they can be tuned without a redeploy.
+type TaskConfig struct {
+	Timeout time.Duration
+
+	// The retry count, it is left to the task when nil
+	Retries *int
+
+	// The circuit breaker of the tasks, there is none when nil
+	Breaker *CircuitBreakerConfig
+
+	// The rate limit of the tasks, there is none when nil
+	RateLimit *RateLimit
+}
+
+// The timeout and the retry count of the tasks with the same name, taken from the configuration
+type taskPolicy struct {
+	Timeout time.Duration
+	Retries *int
+}
+
+// ConfigError is the error of an invalid configuration. The key is the path to the offending setting, like
+// tasks.GetUser.timeout, and the source is the environment variable it came from, if any.
+type ConfigError struct {
+	Key    string
+	Source string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Source != "" {
+		return fmt.Sprintf("config key %s (%s) : %v", e.Key, e.Source, e.Err)
+	}
+	return fmt.Sprintf("config key %s : %v", e.Key, e.Err)
+}
+
+// Use this method to load the configuration from a file and the environment variables. The file is parsed as JSON,
+// when its name ends with .json, and as YAML otherwise. An empty path skips the file.
+//
+// The environment variables with the prefix, like RIO, override the file. The keys are written in upper case and
+// the nested keys are separated by double underscores, except the task and the tenant names, which are kept as they
+// are, like RIO_WORKERS=20, RIO_RETRY__MAX_RETRIES=3 or RIO_TASKS__GetUser__TIMEOUT=300ms. The variables with
+// unknown keys are ignored, only the known keys with invalid values are errors. An empty prefix skips the environment.
+func LoadConfig(path, envPrefix string) (*Config, error) {
+	tree := make(map[string]interface{})
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseConfigData(data, strings.HasSuffix(strings.ToLower(path), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%s : %v", path, err)
+		}
+		if parsed != nil {
+			if tree, err = configObject(parsed, &configDecoder{}, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	d := &configDecoder{sources: make(map[string]string)}
+	if envPrefix != "" {
+		d.overlayEnv(tree, envPrefix, os.Environ())
+	}
+	return d.decode(tree)
+}
+
+// Use this method to parse the configuration from JSON or YAML data, the JSON data is told apart by its leading {
+func ParseConfig(data []byte) (*Config, error) {
+	parsed, err := parseConfigData(data, bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")))
+	if err != nil {
+		return nil, err
+	}
+	d := &configDecoder{}
+	tree, err := configObject(parsed, d, "")
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(tree)
+}
+
+func parseConfigData(data []byte, isJSON bool) (interface{}, error) {
+	if isJSON {
+		var parsed interface{}
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			return nil, err
+		}
+		return parsed, nil
+	}
+	return parseYAML(data)
+}
+
+// Use this method to check the configuration, the returned error is a *ConfigError
+func (c *Config) Validate() error {
+	return c.validate(&configDecoder{})
+}
+
+// Use this method to create a balancer from the configuration. The options are applied after the configuration, so
+// they take precedence.
+func NewFromConfig(c *Config, opts ...Option) (*Balancer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	var configured []Option
+	if c.Workers > 0 {
+		configured = append(configured, WithWorkers(c.Workers))
+	}
+	if c.QueueSize > 0 {
+		configured = append(configured, WithQueueSize(c.QueueSize))
+	}
+	if c.TaskTimeout > 0 || c.RequestTimeout > 0 {
+		configured = append(configured, WithDefaultTimeouts(c.TaskTimeout, c.RequestTimeout))
+	}
+	if c.Retry != nil {
+		configured = append(configured, WithRetryPolicy(*c.Retry))
+	}
+	if c.AdmissionLimit > 0 {
+		configured = append(configured, WithAdmissionLimit(c.AdmissionLimit))
+	}
+	b, err := New(append(configured, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	b.policiesMu.Lock()
+	b.applyTasks(c)
+	b.policiesMu.Unlock()
+	return b, nil
+}
+
+// Use this method to apply a new configuration to a running balancer. The pool is resized, the admission limit, the
+// defaults and the task settings are replaced, while the requests in flight go on. The circuit breakers and the rate
+// limiters, which are not changed, keep their state, and the ones removed from the configuration are removed from
+// the balancer. The queue size can not be changed, as the workers are already created with it.
+func (b *Balancer) Reload(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.QueueSize > 0 && c.QueueSize != b.taskPerWorker {
+		b.logger.Printf("The queue size can not be changed by a reload, it is kept at %d", b.taskPerWorker)
+	}
+	if c.Workers > 0 {
+		if err := b.Resize(c.Workers); err != nil {
+			return err
+		}
+	}
+	if c.AdmissionLimit > 0 {
+		b.SetAdmissionLimit(c.AdmissionLimit)
+	}
+
+	b.policiesMu.Lock()
+	defer b.policiesMu.Unlock()
+	if c.TaskTimeout > 0 {
+		b.taskTimeout = c.TaskTimeout
+	}
+	if c.RequestTimeout > 0 {
+		b.requestTimeout = c.RequestTimeout
+	}
+	if c.Retry != nil {
+		b.retryPolicy = *c.Retry
+	}
+	b.applyTasks(c)
+	b.logger.Printf("Reloaded the configuration")
+	return nil
+}
+
+// Replaces the task policies, the circuit breakers and the rate limiters of the previously applied configuration with
+// the ones of the given one. It is called with the policies mutex locked.
+func (b *Balancer) applyTasks(c *Config) {
+	previous := b.applied
+	if previous == nil {
+		previous = &Config{}
+	}
+
+	b.taskPolicies = make(map[string]taskPolicy, len(c.Tasks))
+	for name, task := range c.Tasks {
+		b.taskPolicies[name] = taskPolicy{Timeout: task.Timeout, Retries: task.Retries}
+	}
+
+	if b.breakers == nil {
+		b.breakers = make(map[string]*CircuitBreaker)
+	}
+	if b.taskLimiters == nil {
+		b.taskLimiters = make(map[string]*RateLimiter)
+	}
+	if b.tenantLimiters == nil {
+		b.tenantLimiters = make(map[string]*RateLimiter)
+	}
+	for name, old := range previous.Tasks {
+		task := c.Tasks[name]
+		if old.Breaker != nil && (task == nil || task.Breaker == nil) {
+			delete(b.breakers, name)
+		}
+		if old.RateLimit != nil && (task == nil || task.RateLimit == nil) {
+			delete(b.taskLimiters, name)
+		}
+	}
+	for name := range previous.Tenants {
+		if c.Tenants[name] == nil {
+			delete(b.tenantLimiters, name)
+		}
+	}
+
+	for name, task := range c.Tasks {
+		old := previous.Tasks[name]
+		if task.Breaker != nil && (old == nil || old.Breaker == nil || *old.Breaker != *task.Breaker || b.breakers[name] == nil) {
+			b.breakers[name] = NewCircuitBreaker(name, *task.Breaker)
+		}
+		if task.RateLimit != nil && (old == nil || old.RateLimit == nil || *old.RateLimit != *task.RateLimit || b.taskLimiters[name] == nil) {
+			limiter := NewRateLimiter(name, *task.RateLimit)
+			limiter.kind = TaskRateLimiter
+			b.taskLimiters[name] = limiter
+		}
+	}
+	for name, limit := range c.Tenants {
+		old := previous.Tenants[name]
+		if old == nil || *old != *limit || b.tenantLimiters[name] == nil {
+			limiter := NewRateLimiter(name, *limit)
+			limiter.kind = TenantRateLimiter
+			b.tenantLimiters[name] = limiter
+		}
+	}
+	b.applied = c
+}
+
+// The decoder of the parsed configuration tree. It keeps the environment variables, the keys are taken from, to point
+// to them in the errors.
+type configDecoder struct {
+	sources map[string]string
+}
+
+func (d *configDecoder) errorf(key, format string, args ...interface{}) error {
+	return &ConfigError{Key: key, Source: d.sources[key], Err: fmt.Errorf(format, args...)}
+}
+
+// Sets the values of the environment variables with the prefix in the tree. The variables, which do not fit in the
+// tree, like RIO_WORKERS__COUNT as workers is not an object, are skipped, as are the unknown keys on decoding, since
+// the other programs may share the prefix.
+func (d *configDecoder) overlayEnv(tree map[string]interface{}, prefix string, environ []string) {
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+	sort.Strings(environ)
+variables:
+	for _, variable := range environ {
+		eq := strings.Index(variable, "=")
+		if eq < 0 || !strings.HasPrefix(variable[:eq], prefix) {
+			continue
+		}
+		name, value := variable[:eq], variable[eq+1:]
+
+		segments := strings.Split(name[len(prefix):], "__")
+		for i, segment := range segments {
+			// The task and the tenant names are kept as they are
+			if i == 1 && (segments[0] == "tasks" || segments[0] == "tenants") {
+				continue
+			}
+			segments[i] = strings.ToLower(segment)
+		}
+
+		node := tree
+		for i, segment := range segments[:len(segments)-1] {
+			if !configObjectKey(segments[:i+1]) {
+				continue variables
+			}
+			path := strings.Join(segments[:i+1], ".")
+			child, err := configObject(node[segment], d, path)
+			if err != nil {
+				continue variables
+			}
+			node[segment] = child
+			node = child
+		}
+		node[segments[len(segments)-1]] = value
+		d.sources[strings.Join(segments, ".")] = name
+	}
+}
+
+// Tells if the key is of an object in the configuration, like retry or tasks.GetUser.breaker
+func configObjectKey(segments []string) bool {
+	switch len(segments) {
+	case 1:
+		return segments[0] == "retry" || segments[0] == "tasks" || segments[0] == "tenants"
+	case 2:
+		return segments[0] == "tasks" || segments[0] == "tenants"
+	case 3:
+		return segments[0] == "tasks" && (segments[2] == "breaker" || segments[2] == "rate_limit")
+	}
+	return false
+}
+
+func (d *configDecoder) decode(tree map[string]interface{}) (*Config, error) {
+	c := &Config{}
+	err := d.fields(tree, "", map[string]func(interface{}, string) error{
+		"workers":         d.intField(&c.Workers),
+		"queue_size":      d.intField(&c.QueueSize),
+		"task_timeout":    d.durationField(&c.TaskTimeout),
+		"request_timeout": d.durationField(&c.RequestTimeout),
+		"admission_limit": d.intField(&c.AdmissionLimit),
+		"retry": func(value interface{}, key string) error {
+			object, err := configObject(value, d, key)
+			if err != nil {
+				return err
+			}
+			c.Retry = &RetryPolicy{}
+			return d.fields(object, key, map[string]func(interface{}, string) error{
+				"max_retries": d.intField(&c.Retry.MaxRetries),
+				"backoff":     d.durationField(&c.Retry.Backoff),
+				"multiplier":  d.floatField(&c.Retry.Multiplier),
+				"max_backoff": d.durationField(&c.Retry.MaxBackoff),
+			})
+		},
+		"tasks": func(value interface{}, key string) error {
+			object, err := configObject(value, d, key)
+			if err != nil {
+				return err
+			}
+			c.Tasks = make(map[string]*TaskConfig, len(object))
+			for _, name := range sortedKeys(object) {
+				task, err := d.task(object[name], key+"."+name)
+				if err != nil {
+					return err
+				}
+				c.Tasks[name] = task
+			}
+			return nil
+		},
+		"tenants": func(value interface{}, key string) error {
+			object, err := configObject(value, d, key)
+			if err != nil {
+				return err
+			}
+			c.Tenants = make(map[string]*RateLimit, len(object))
+			for _, name := range sortedKeys(object) {
+				limit, err := d.rateLimit(object[name], key+"."+name)
+				if err != nil {
+					return err
+				}
+				c.Tenants[name] = limit
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.validate(d); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (d *configDecoder) task(value interface{}, key string) (*TaskConfig, error) {
+	object, err := configObject(value, d, key)
+	if err != nil {
+		return nil, err
+	}
+	task := &TaskConfig{}
+	return task, d.fields(object, key, map[string]func(interface{}, string) error{
+		"timeout": d.durationField(&task.Timeout),
+		"retries": func(value interface{}, key string) error {
+			task.Retries = new(int)
+			return d.intField(task.Retries)(value, key)
+		},
+		"breaker": func(value interface{}, key string) error {
+			object, err := configObject(value, d, key)
+			if err != nil {
+				return err
+			}
+			task.Breaker = &CircuitBreakerConfig{}
+			return d.fields(object, key, map[string]func(interface{}, string) error{
+				"failure_rate_threshold":   d.floatField(&task.Breaker.FailureRateThreshold),
+				"slow_call_duration":       d.durationField(&task.Breaker.SlowCallDuration),
+				"slow_call_rate_threshold": d.floatField(&task.Breaker.SlowCallRateThreshold),
+				"window":                   d.durationField(&task.Breaker.Window),
+				"minimum_calls":            d.intField(&task.Breaker.MinimumCalls),
+				"open_duration":            d.durationField(&task.Breaker.OpenDuration),
+				"half_open_calls":          d.intField(&task.Breaker.HalfOpenCalls),
+			})
+		},
+		"rate_limit": func(value interface{}, key string) error {
+			task.RateLimit, err = d.rateLimit(value, key)
+			return err
+		},
+	})
+}
+
+func (d *configDecoder) rateLimit(value interface{}, key string) (*RateLimit, error) {
+	object, err := configObject(value, d, key)
+	if err != nil {
+		return nil, err
+	}
+	limit := &RateLimit{}
+	return limit, d.fields(object, key, map[string]func(interface{}, string) error{
+		"rate":  d.floatField(&limit.Rate),
+		"burst": d.intField(&limit.Burst),
+		"wait":  d.boolField(&limit.Wait),
+	})
+}
+
+// Decodes the fields of the object, in the order of their keys. A key without a field is an error.
+func (d *configDecoder) fields(object map[string]interface{}, key string, fields map[string]func(interface{}, string) error) error {
+	for _, name := range sortedKeys(object) {
+		path := name
+		if key != "" {
+			path = key + "." + name
+		}
+		field, found := fields[name]
+		if !found && d.sources[path] != "" {
+			// Set by an environment variable of another program with the same prefix
+			continue
+		}
+		if !found {
+			return d.errorf(path, "unknown key")
+		}
+		if object[name] == nil {
+			continue
+		}
+		if err := field(object[name], path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *configDecoder) intField(target *int) func(interface{}, string) error {
+	return func(value interface{}, key string) error {
+		switch v := value.(type) {
+		case int64:
+			*target = int(v)
+			return nil
+		case float64:
+			if v == math.Trunc(v) {
+				*target = int(v)
+				return nil
+			}
+		case string:
+			if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				*target = i
+				return nil
+			}
+		}
+		return d.errorf(key, "expected an integer, got %v", value)
+	}
+}
+
+func (d *configDecoder) floatField(target *float64) func(interface{}, string) error {
+	return func(value interface{}, key string) error {
+		switch v := value.(type) {
+		case int64:
+			*target = float64(v)
+			return nil
+		case float64:
+			*target = v
+			return nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				*target = f
+				return nil
+			}
+		}
+		return d.errorf(key, "expected a number, got %v", value)
+	}
+}
+
+func (d *configDecoder) boolField(target *bool) func(interface{}, string) error {
+	return func(value interface{}, key string) error {
+		switch v := value.(type) {
+		case bool:
+			*target = v
+			return nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				*target = b
+				return nil
+			}
+		}
+		return d.errorf(key, "expected true or false, got %v", value)
+	}
+}
+
+func (d *configDecoder) durationField(target *time.Duration) func(interface{}, string) error {
+	return func(value interface{}, key string) error {
+		switch v := value.(type) {
+		case int64:
+			if v == 0 {
+				*target = 0
+				return nil
+			}
+		case float64:
+			if v == 0 {
+				*target = 0
+				return nil
+			}
+		case string:
+			if duration, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+				*target = duration
+				return nil
+			}
+		}
+		return d.errorf(key, "expected a duration like 100ms, got %v", value)
+	}
+}
+
+// The value as an object, a missing value is an empty object
+func configObject(value interface{}, d *configDecoder, key string) (map[string]interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return make(map[string]interface{}), nil
+	case map[string]interface{}:
+		return v, nil
+	}
+	if key == "" {
+		return nil, &ConfigError{Key: "(root)", Err: fmt.Errorf("expected an object, got %v", value)}
+	}
+	return nil, d.errorf(key, "expected an object, got %v", value)
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Config) validate(d *configDecoder) error {
+	switch {
+	case c.Workers < 0:
+		return d.errorf("workers", "can not be negative")
+	case c.QueueSize < 0:
+		return d.errorf("queue_size", "can not be negative")
+	case c.TaskTimeout < 0:
+		return d.errorf("task_timeout", "can not be negative")
+	case c.RequestTimeout < 0:
+		return d.errorf("request_timeout", "can not be negative")
+	case c.RequestTimeout > 0 && c.TaskTimeout > c.RequestTimeout:
+		return d.errorf("task_timeout", "%v is longer than the request timeout %v", c.TaskTimeout, c.RequestTimeout)
+	case c.AdmissionLimit < 0:
+		return d.errorf("admission_limit", "can not be negative")
+	case c.AdmissionLimit > 0 && c.AdmissionLimit < c.Workers:
+		return d.errorf("admission_limit", "%d is lower than the worker count %d", c.AdmissionLimit, c.Workers)
+	}
+
+	if r := c.Retry; r != nil {
+		switch {
+		case r.MaxRetries < 0:
+			return d.errorf("retry.max_retries", "can not be negative")
+		case r.Backoff < 0:
+			return d.errorf("retry.backoff", "can not be negative")
+		case r.Multiplier < 0:
+			return d.errorf("retry.multiplier", "can not be negative")
+		case r.MaxBackoff < 0:
+			return d.errorf("retry.max_backoff", "can not be negative")
+		}
+	}
+
+	for _, name := range sortedTaskNames(c.Tasks) {
+		key := "tasks." + name
+		task := c.Tasks[name]
+		switch {
+		case name == "":
+			return d.errorf("tasks", "the task name can not be empty")
+		case task == nil:
+			return d.errorf(key, "the task settings are missing")
+		case task.Timeout < 0:
+			return d.errorf(key+".timeout", "can not be negative")
+		case task.Retries != nil && *task.Retries < 0:
+			return d.errorf(key+".retries", "can not be negative")
+		}
+		if err := validateBreaker(d, key+".breaker", task.Breaker); err != nil {
+			return err
+		}
+		if err := validateRateLimit(d, key+".rate_limit", task.RateLimit); err != nil {
+			return err
+		}
+	}
+
+	tenants := make([]string, 0, len(c.Tenants))
+	for name := range c.Tenants {
+		tenants = append(tenants, name)
+	}
+	sort.Strings(tenants)
+	for _, name := range tenants {
+		if name == "" {
+			return d.errorf("tenants", "the tenant name can not be empty")
+		}
+		if c.Tenants[name] == nil {
+			return d.errorf("tenants."+name, "the rate limit is missing")
+		}
+		if err := validateRateLimit(d, "tenants."+name, c.Tenants[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedTaskNames(tasks map[string]*TaskConfig) []string {
+	names := make([]string, 0, len(tasks))
+	for name := range tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateBreaker(d *configDecoder, key string, config *CircuitBreakerConfig) error {
+	if config == nil {
+		return nil
+	}
+	switch {
+	case config.FailureRateThreshold < 0 || config.FailureRateThreshold > 1:
+		return d.errorf(key+".failure_rate_threshold", "must be between 0 and 1")
+	case config.SlowCallRateThreshold < 0 || config.SlowCallRateThreshold > 1:
+		return d.errorf(key+".slow_call_rate_threshold", "must be between 0 and 1")
+	case config.SlowCallDuration < 0:
+		return d.errorf(key+".slow_call_duration", "can not be negative")
+	case config.Window < 0:
+		return d.errorf(key+".window", "can not be negative")
+	case config.MinimumCalls < 0:
+		return d.errorf(key+".minimum_calls", "can not be negative")
+	case config.OpenDuration < 0:
+		return d.errorf(key+".open_duration", "can not be negative")
+	case config.HalfOpenCalls < 0:
+		return d.errorf(key+".half_open_calls", "can not be negative")
+	}
+	return nil
+}
+
+func validateRateLimit(d *configDecoder, key string, limit *RateLimit) error {
+	if limit == nil {
+		return nil
+	}
+	switch {
+	case limit.Rate <= 0:
+		return d.errorf(key+".rate", "must be positive")
+	case limit.Burst < 0:
+		return d.errorf(key+".burst", "can not be negative")
+	}
+	return nil
+}
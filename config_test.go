@@ -0,0 +1,201 @@
+package rio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testConfigYAML = `
+workers: 4
+queue_size: 2
+task_timeout: 500ms
+request_timeout: 2s
+retry:
+  max_retries: 2
+  backoff: 10ms
+tasks:
+  GetUser:
+    timeout: 30ms
+    retries: 0
+    breaker:
+      failure_rate_threshold: 0.25
+      minimum_calls: 5
+    rate_limit:
+      rate: 100
+      burst: 10
+      wait: true
+tenants:
+  acme:
+    rate: 50
+`
+
+const testConfigJSON = `{
+	"workers": 4,
+	"queue_size": 2,
+	"task_timeout": "500ms",
+	"request_timeout": "2s",
+	"retry": {"max_retries": 2, "backoff": "10ms"},
+	"tasks": {
+		"GetUser": {
+			"timeout": "30ms",
+			"retries": 0,
+			"breaker": {"failure_rate_threshold": 0.25, "minimum_calls": 5},
+			"rate_limit": {"rate": 100, "burst": 10, "wait": true}
+		}
+	},
+	"tenants": {"acme": {"rate": 50}}
+}`
+
+func TestParseConfig(t *testing.T) {
+	for _, data := range []string{testConfigYAML, testConfigJSON} {
+		c, err := ParseConfig([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		task := c.Tasks["GetUser"]
+		if c.Workers != 4 || c.QueueSize != 2 || c.TaskTimeout != 500*time.Millisecond || c.RequestTimeout != 2*time.Second ||
+			c.Retry.MaxRetries != 2 || c.Retry.Backoff != 10*time.Millisecond {
+			t.Errorf("unexpected config : %+v", c)
+		}
+		if task.Timeout != 30*time.Millisecond || task.Retries == nil || *task.Retries != 0 ||
+			task.Breaker.FailureRateThreshold != 0.25 || task.Breaker.MinimumCalls != 5 ||
+			*task.RateLimit != (RateLimit{Rate: 100, Burst: 10, Wait: true}) || c.Tenants["acme"].Rate != 50 {
+			t.Errorf("unexpected task config : %+v", task)
+		}
+	}
+}
+
+func TestConfigErrorsPointToTheKey(t *testing.T) {
+	invalid := map[string]string{
+		"workers: many":                                  "workers",
+		"wokers: 2":                                      "wokers",
+		"tasks:\n  GetUser:\n    timout: 1s":             "tasks.GetUser.timout",
+		"tasks:\n  GetUser:\n    timeout: fast":          "tasks.GetUser.timeout",
+		"tasks:\n  GetUser:\n    retries: -1":            "tasks.GetUser.retries",
+		"tasks:\n  GetUser:\n    breaker: open":          "tasks.GetUser.breaker",
+		"tenants:\n  acme:\n    rate: 0":                 "tenants.acme.rate",
+		"task_timeout: 2s\nrequest_timeout: 1s":          "task_timeout",
+		"{\"retry\": {\"multiplier\": -2}}":              "retry.multiplier",
+		"{\"tasks\": {\"GetUser\": {\"retries\": 1.5}}}": "tasks.GetUser.retries",
+	}
+	for data, key := range invalid {
+		_, err := ParseConfig([]byte(data))
+		if configErr, ok := err.(*ConfigError); !ok || configErr.Key != key {
+			t.Errorf("%q : unexpected error %v", data, err)
+		}
+	}
+}
+
+func TestLoadConfigFromFileAndEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rio.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfigYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("RIOTEST_WORKERS", "8")
+	os.Setenv("RIOTEST_TASKS__GetUser__TIMEOUT", "40ms")
+	os.Setenv("RIOTEST_TASKS__GetOrder__RATE_LIMIT__RATE", "5")
+	defer os.Unsetenv("RIOTEST_WORKERS")
+	defer os.Unsetenv("RIOTEST_TASKS__GetUser__TIMEOUT")
+	defer os.Unsetenv("RIOTEST_TASKS__GetOrder__RATE_LIMIT__RATE")
+
+	c, err := LoadConfig(path, "RIOTEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Workers != 8 || c.QueueSize != 2 || c.Tasks["GetUser"].Timeout != 40*time.Millisecond ||
+		c.Tasks["GetUser"].Breaker == nil || c.Tasks["GetOrder"].RateLimit.Rate != 5 {
+		t.Errorf("unexpected config : %+v", c)
+	}
+
+	// The unknown keys are of the other programs with the same prefix, even the ones under a scalar field
+	os.Setenv("RIOTEST_HOME", "/opt/riotest")
+	os.Setenv("RIOTEST_ADMISSION_LIMIT__COUNT", "3")
+	os.Setenv("RIOTEST_RETRY__BACKOFF__UNIT", "ms")
+	defer os.Unsetenv("RIOTEST_ADMISSION_LIMIT__COUNT")
+	defer os.Unsetenv("RIOTEST_RETRY__BACKOFF__UNIT")
+	os.Setenv("RIOTEST_WORKERS__COUNT", "3")
+	os.Setenv("RIOTEST_TASKS__GetUser__OWNER", "users")
+	defer os.Unsetenv("RIOTEST_HOME")
+	defer os.Unsetenv("RIOTEST_WORKERS__COUNT")
+	defer os.Unsetenv("RIOTEST_TASKS__GetUser__OWNER")
+	if c, err = LoadConfig(path, "RIOTEST"); err != nil || c.Workers != 8 {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	// When workers is set nowhere else, it is not made an object for RIOENV_WORKERS__COUNT either
+	os.Setenv("RIOENV_WORKERS__COUNT", "3")
+	defer os.Unsetenv("RIOENV_WORKERS__COUNT")
+	if c, err := LoadConfig("", "RIOENV"); err != nil || c.Workers != 0 {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	os.Setenv("RIOTEST_RETRY__BACKOFF", "soon")
+	defer os.Unsetenv("RIOTEST_RETRY__BACKOFF")
+	_, err = LoadConfig(path, "RIOTEST")
+	if configErr, ok := err.(*ConfigError); !ok || configErr.Key != "retry.backoff" || configErr.Source != "RIOTEST_RETRY__BACKOFF" {
+		t.Errorf("unexpected error : %v", err)
+	}
+}
+
+func TestNewFromConfigAndReload(t *testing.T) {
+	c, err := ParseConfig([]byte("workers: 2\ntasks:\n  Slow:\n    timeout: 20ms\n    breaker:\n      minimum_calls: 5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balancer, err := NewFromConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaker := balancer.CircuitBreaker("Slow")
+
+	// The timeout of the configuration overrides the one of the task
+	task := NewNamedFutureTask("Slow", sleepingTask(50*time.Millisecond, nil)).WithSecondTimeout(1)
+	future, _ := balancer.Submit(BuildRequests(context.Background(), task))
+	if _, err := future.Wait(context.Background()); err != ErrTimeout || breaker == nil {
+		t.Errorf("unexpected error : %v", err)
+	}
+
+	// An unchanged breaker keeps its state, the new settings are applied
+	c, _ = ParseConfig([]byte("workers: 3\ntasks:\n  Slow:\n    timeout: 100ms\n    breaker:\n      minimum_calls: 5\n" +
+		"tenants:\n  acme:\n    rate: 10"))
+	if err := balancer.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	future, _ = balancer.Submit(BuildRequests(context.Background(), task))
+	if _, err := future.Wait(context.Background()); err != nil {
+		t.Errorf("unexpected error : %v", err)
+	}
+	if balancer.CircuitBreaker("Slow") != breaker || balancer.TenantRateLimiter("acme") == nil {
+		t.Fail()
+	}
+	if stats, _ := balancer.Stats(); stats.Workers != 3 {
+		t.Errorf("unexpected worker count : %d", stats.Workers)
+	}
+
+	// The policies removed from the configuration are removed from the balancer, the ones added in code are kept
+	balancer.AddTaskRateLimiter("Other", RateLimit{Rate: 1})
+	if err := balancer.Reload(&Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if balancer.CircuitBreaker("Slow") != nil || balancer.TenantRateLimiter("acme") != nil ||
+		balancer.TaskRateLimiter("Other") == nil {
+		t.Fail()
+	}
+	if err := balancer.Reload(&Config{Workers: -1}); err == nil {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
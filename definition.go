@@ -0,0 +1,252 @@
+package rio
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// PipelineDefinition is the declarative form of a pipeline, which refers to the callbacks, the bridges and the nested
+// pipelines by their names in a Registry. It is parsed from JSON or YAML, see ParsePipelineDefinition.
+//
+//	name: Profile
+//	tasks:
+//	  - name: GetUser
+//	    callback: getUser
+//	    timeout: 100ms
+//	    retries: 2
+//	  - name: GetAvatar
+//	    timeout: 50ms
+//	    replicas: 2
+//	edges:
+//	  - from: GetUser
+//	    to: GetAvatar
+//	    bridge: userToAvatar
+//
+// The edges chain the tasks one after another, starting from the task without an incoming edge, so every task but the
+// first must have exactly one incoming edge and the tasks must not branch out.
+type PipelineDefinition struct {
+	Name  string
+	Tasks []*TaskDefinition
+	Edges []*EdgeDefinition
+}
+
+// The declarative form of a task
+type TaskDefinition struct {
+	Name string
+
+	// The name of the callback or the task in the registry, it is the name of the task, when it is empty
+	Callback string
+
+	// The name of the pipeline in the registry, which the task runs instead of a callback, see NewPipelineTask
+	Pipeline string
+
+	Timeout  time.Duration
+	Retries  int
+	Replicas int
+	Bulkhead string
+}
+
+// The declarative form of a bridge between two tasks, it has either a bridge or a history bridge
+type EdgeDefinition struct {
+	From          string
+	To            string
+	Bridge        string
+	HistoryBridge string
+
+	// The index of the edge in the definition, used in the errors
+	index int
+}
+
+// The name of the callback in the registry
+func (t *TaskDefinition) callback() string {
+	if t.Callback != "" {
+		return t.Callback
+	}
+	return t.Name
+}
+
+// Use this method to parse the JSON or YAML pipeline definition, the JSON data is told apart by its leading {. The
+// errors are *ConfigError with the key of the offending setting, like tasks[1].timeout.
+func ParsePipelineDefinition(data []byte) (*PipelineDefinition, error) {
+	parsed, err := parseConfigData(data, bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")))
+	if err != nil {
+		return nil, err
+	}
+	d := &configDecoder{}
+	tree, err := configObject(parsed, d, "")
+	if err != nil {
+		return nil, err
+	}
+
+	definition := &PipelineDefinition{}
+	err = d.fields(tree, "", map[string]func(interface{}, string) error{
+		"name": d.stringField(&definition.Name),
+		"tasks": func(value interface{}, key string) error {
+			return d.list(value, key, func(item interface{}, key string) error {
+				task := &TaskDefinition{}
+				definition.Tasks = append(definition.Tasks, task)
+				return d.object(item, key, map[string]func(interface{}, string) error{
+					"name":     d.stringField(&task.Name),
+					"callback": d.stringField(&task.Callback),
+					"pipeline": d.stringField(&task.Pipeline),
+					"timeout":  d.durationField(&task.Timeout),
+					"retries":  d.intField(&task.Retries),
+					"replicas": d.intField(&task.Replicas),
+					"bulkhead": d.stringField(&task.Bulkhead),
+				})
+			})
+		},
+		"edges": func(value interface{}, key string) error {
+			return d.list(value, key, func(item interface{}, key string) error {
+				edge := &EdgeDefinition{index: len(definition.Edges)}
+				definition.Edges = append(definition.Edges, edge)
+				return d.object(item, key, map[string]func(interface{}, string) error{
+					"from":           d.stringField(&edge.From),
+					"to":             d.stringField(&edge.To),
+					"bridge":         d.stringField(&edge.Bridge),
+					"history_bridge": d.stringField(&edge.HistoryBridge),
+				})
+			})
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := definition.validate(d); err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
+
+// Use this method to check the definition, the returned error is a *ConfigError
+func (p *PipelineDefinition) Validate() error {
+	return p.validate(&configDecoder{})
+}
+
+func (p *PipelineDefinition) validate(d *configDecoder) error {
+	if len(p.Tasks) == 0 {
+		return d.errorf("tasks", "there are no tasks")
+	}
+	tasks := make(map[string]bool, len(p.Tasks))
+	for i, task := range p.Tasks {
+		key := fmt.Sprintf("tasks[%d]", i)
+		switch {
+		case task == nil:
+			return d.errorf(key, "the task is missing")
+		case task.Name == "":
+			return d.errorf(key+".name", "the task has no name")
+		case tasks[task.Name]:
+			return d.errorf(key+".name", "the task name %q is repeated", task.Name)
+		case task.Callback != "" && task.Pipeline != "":
+			return d.errorf(key+".pipeline", "a task has either a callback or a pipeline")
+		case task.Timeout < 0:
+			return d.errorf(key+".timeout", "can not be negative")
+		case task.Retries < 0:
+			return d.errorf(key+".retries", "can not be negative")
+		case task.Replicas < 0:
+			return d.errorf(key+".replicas", "can not be negative")
+		}
+		tasks[task.Name] = true
+	}
+
+	incoming := make(map[string]bool, len(p.Edges))
+	outgoing := make(map[string]bool, len(p.Edges))
+	for i, edge := range p.Edges {
+		key := fmt.Sprintf("edges[%d]", i)
+		switch {
+		case edge == nil:
+			return d.errorf(key, "the edge is missing")
+		case !tasks[edge.From]:
+			return d.errorf(key+".from", "there is no task named %q", edge.From)
+		case !tasks[edge.To]:
+			return d.errorf(key+".to", "there is no task named %q", edge.To)
+		case edge.From == edge.To:
+			return d.errorf(key+".to", "the task %q can not follow itself", edge.To)
+		case edge.Bridge == "" && edge.HistoryBridge == "":
+			return d.errorf(key+".bridge", "the edge has no bridge")
+		case edge.Bridge != "" && edge.HistoryBridge != "":
+			return d.errorf(key+".history_bridge", "an edge has either a bridge or a history bridge")
+		case outgoing[edge.From]:
+			return d.errorf(key+".from", "the task %q has more than one next task", edge.From)
+		case incoming[edge.To]:
+			return d.errorf(key+".to", "the task %q has more than one previous task", edge.To)
+		}
+		edge.index = i
+		outgoing[edge.From] = true
+		incoming[edge.To] = true
+	}
+
+	// With at most one edge in and out of each task, the edges chain all the tasks, if there is one edge less than
+	// the tasks and no cycle, which is when a single task has no previous task
+	if len(p.Edges) != len(p.Tasks)-1 {
+		return d.errorf("edges", "%d edges can not chain %d tasks one after another", len(p.Edges), len(p.Tasks))
+	}
+	if order, _ := p.chain(); len(order) != len(p.Tasks) {
+		return d.errorf("edges", "the edges make a cycle")
+	}
+	return nil
+}
+
+// The indexes of the tasks in the order of the chain and the edges between them, the definition must be valid
+func (p *PipelineDefinition) chain() ([]int, []*EdgeDefinition) {
+	index := make(map[string]int, len(p.Tasks))
+	for i, task := range p.Tasks {
+		index[task.Name] = i
+	}
+	next := make(map[string]*EdgeDefinition, len(p.Edges))
+	hasPrevious := make(map[string]bool, len(p.Edges))
+	for _, edge := range p.Edges {
+		next[edge.From] = edge
+		hasPrevious[edge.To] = true
+	}
+
+	var order []int
+	var edges []*EdgeDefinition
+	for _, task := range p.Tasks {
+		if hasPrevious[task.Name] {
+			continue
+		}
+		order = append(order, index[task.Name])
+		for edge := next[task.Name]; edge != nil && len(order) <= len(p.Tasks); edge = next[edge.To] {
+			order = append(order, index[edge.To])
+			edges = append(edges, edge)
+		}
+		break
+	}
+	return order, edges
+}
+
+// Decodes the list, calling the item function with the key of each item, like tasks[0]
+func (d *configDecoder) list(value interface{}, key string, item func(interface{}, string) error) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		return d.errorf(key, "expected a list, got %v", value)
+	}
+	for i, value := range items {
+		if err := item(value, fmt.Sprintf("%s[%d]", key, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decodes the fields of the object value
+func (d *configDecoder) object(value interface{}, key string, fields map[string]func(interface{}, string) error) error {
+	object, err := configObject(value, d, key)
+	if err != nil {
+		return err
+	}
+	return d.fields(object, key, fields)
+}
+
+func (d *configDecoder) stringField(target *string) func(interface{}, string) error {
+	return func(value interface{}, key string) error {
+		s, ok := value.(string)
+		if !ok {
+			return d.errorf(key, "expected a string, got %v", value)
+		}
+		*target = s
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+package rio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const testPipelineYAML = `
+name: Profile
+tasks:
+  # The order of the tasks comes from the edges
+  - name: GetAvatar
+    timeout: 50ms
+    replicas: 2
+  - name: GetUser
+    callback: user
+    timeout: 100ms
+    retries: 2
+  - name: Render
+    pipeline: Page
+edges:
+  - from: GetUser
+    to: GetAvatar
+    bridge: pass
+  - from: GetAvatar
+    to: Render
+    history_bridge: both
+`
+
+func testRegistry() *Registry {
+	registry := NewRegistry()
+	registry.RegisterCallback("user", echoTask("user "))
+	registry.RegisterCallback("GetAvatar", echoTask("avatar of "))
+	registry.RegisterBridge("pass", passBridge)
+	registry.RegisterHistoryBridge("both", func(h *History) *BridgeConnection {
+		return &BridgeConnection{Data: []interface{}{h.Named("GetUser").Data.(string) + ", " + h.Named("GetAvatar").Data.(string)}}
+	})
+	registry.RegisterPipeline(NewPipeline("Page", NewFutureTask(echoTask("page with "))))
+	return registry
+}
+
+func TestParsePipelineDefinition(t *testing.T) {
+	definition, err := ParsePipelineDefinition([]byte(testPipelineYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := definition.Tasks[1]
+	if definition.Name != "Profile" || len(definition.Tasks) != 3 || len(definition.Edges) != 2 ||
+		user.Callback != "user" || user.Timeout != 100*time.Millisecond || user.Retries != 2 ||
+		definition.Tasks[0].Replicas != 2 || definition.Edges[1].HistoryBridge != "both" {
+		t.Errorf("unexpected definition : %+v", definition)
+	}
+
+	json := `{"name": "Profile", "tasks": [{"name": "GetUser", "callback": "user", "timeout": "100ms", "retries": 2}]}`
+	if definition, err = ParsePipelineDefinition([]byte(json)); err != nil || definition.Tasks[0].Retries != 2 {
+		t.Errorf("unexpected result : %v", err)
+	}
+}
+
+func TestPipelineDefinitionErrors(t *testing.T) {
+	invalid := map[string]string{
+		"tasks: []":                              "tasks",
+		"tasks:\n  - name: A\n    timeout: soon": "tasks[0].timeout",
+		"tasks:\n  - name: A\n  - name: A":       "tasks[1].name",
+		"tasks:\n  - name: A\n    retires: 1":    "tasks[0].retires",
+		"tasks:\n  - name: A\n    replicas: -1":  "tasks[0].replicas",
+		"tasks:\n  - name: A\n  - name: B":       "edges",
+		"tasks:\n  - name: A\n  - name: B\nedges:\n  - from: A\n    to: C\n    bridge: pass":                                                  "edges[0].to",
+		"tasks:\n  - name: A\n  - name: B\nedges:\n  - from: A\n    to: B":                                                                    "edges[0].bridge",
+		"tasks:\n  - name: A\n  - name: B\n  - name: C\nedges:\n  - from: A\n    to: B\n    bridge: x\n  - from: A\n    to: C\n    bridge: x": "edges[1].from",
+		"tasks:\n  - name: A\n  - name: B\n  - name: C\nedges:\n  - from: B\n    to: C\n    bridge: x\n  - from: C\n    to: B\n    bridge: x": "edges",
+	}
+	for data, key := range invalid {
+		_, err := ParsePipelineDefinition([]byte(data))
+		if configErr, ok := err.(*ConfigError); !ok || configErr.Key != key {
+			t.Errorf("%q : unexpected error %v", data, err)
+		}
+	}
+
+	// The names, which are not registered, are reported by the build
+	unknown := map[string]string{
+		"tasks:\n  - name: Missing":                  "tasks[0].callback",
+		"tasks:\n  - name: A\n    pipeline: Missing": "tasks[0].pipeline",
+		"tasks:\n  - name: user\n  - name: GetAvatar\nedges:\n  - from: user\n    to: GetAvatar\n    bridge: missing": "edges[0].bridge",
+	}
+	for data, key := range unknown {
+		_, err := testRegistry().ParsePipeline([]byte(data))
+		if configErr, ok := err.(*ConfigError); !ok || configErr.Key != key {
+			t.Errorf("%q : unexpected error %v", data, err)
+		}
+	}
+}
+
+func TestRunDeclarativePipeline(t *testing.T) {
+	pipeline, err := testRegistry().ParsePipeline([]byte(testPipelineYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pipeline.Name() != "Profile" || pipeline.tasks[0].Name != "GetUser" || pipeline.tasks[0].RetryCount != 2 ||
+		pipeline.tasks[1].ReplicaCount != 2 || pipeline.tasks[2].Pipeline == nil {
+		t.Errorf("unexpected pipeline : %+v", pipeline.tasks)
+	}
+
+	balancer := GetBalancer(1, 1)
+	future, _ := balancer.Submit(pipeline.NewRequest(context.Background(), "42"))
+	responses, err := future.Wait(context.Background())
+	if err != nil || responses[2].Data != "page with user 42, avatar of user 42" {
+		t.Errorf("unexpected result : %v", err)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestBuildWithRegisteredTask(t *testing.T) {
+	registry := testRegistry()
+	registry.RegisterTask("lookup", NewNamedFutureTask("lookup", echoTask("found ")).WithSecondTimeout(1).WithRetry(1))
+
+	// Every task gets its own copy, with the settings of the definition overriding the ones of the registered task
+	pipeline, err := registry.ParsePipeline([]byte("tasks:\n  - name: A\n    callback: lookup\n    retries: 3\n  - name: B\n    callback: lookup\nedges:\n  - from: A\n    to: B\n    bridge: pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := pipeline.tasks[0], pipeline.tasks[1]
+	if a == b || a.Name != "A" || a.RetryCount != 3 || a.Timeout != time.Second || b.Name != "B" || b.RetryCount != 1 {
+		t.Errorf("unexpected tasks : %+v, %+v", a, b)
+	}
+}
@@ -78,14 +78,21 @@ func SampleHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Set up the pipeline
 	request := rio.BuildRequests(context.Background(),
-		rio.NewFutureTask(callback1).WithMilliSecondTimeout(10).WithRetry(3), 2).
+		rio.NewFutureTask(callback1).WithMilliSecondTimeout(10).WithRetry(3)).
 		FollowedBy(Call1ToCall2, rio.NewFutureTask(callback2).WithMilliSecondTimeout(20))
 
 	// Post job
-	balancer.PostJob(request)
+	future, err := balancer.Submit(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	// Wait for response
-	<-request.CompletedChannel
+	if _, err := future.Wait(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	}
 
 	// Responses
 	response1, err := request.GetResponse(0)
@@ -0,0 +1,283 @@
+package rio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// OutputFormat tells how the stdout of a command is parsed into the data of the response
+type OutputFormat int
+
+const (
+	// The data is the stdout as a string, as it is
+	RawOutput OutputFormat = iota
+
+	// The data is the stdout decoded as JSON, into the target of the command
+	JSONOutput
+
+	// The data is the lines of the stdout as a []string, without the line endings and the last empty line
+	LinesOutput
+)
+
+func (f OutputFormat) String() string {
+	switch f {
+	case RawOutput:
+		return "raw"
+	case JSONOutput:
+		return "json"
+	case LinesOutput:
+		return "lines"
+	}
+	return fmt.Sprintf("OutputFormat(%d)", int(f))
+}
+
+// Command describes the local process of a task, see NewExecTask. The arguments and the stdin are templates, like the
+// ones of HTTPCall, which are executed with the data of the bridge connection.
+//
+//	rio.Command{
+//		Path:   "convert",
+//		Args:   []string{"{{index . 0}}", "-resize", "64x64", "{{index . 0}}.thumb.png"},
+//		Output: rio.LinesOutput,
+//	}
+type Command struct {
+	// The program to run, it is looked up in the PATH, when it has no path separator
+	Path string
+
+	Args []string
+
+	// The stdin of the process, there is none when it is empty
+	Stdin string
+
+	// The working directory and the environment of the process, default is the ones of the current process
+	Dir string
+	Env []string
+
+	Output OutputFormat
+
+	// When it is set, the JSON output is decoded into a new value of its type, like the target of HTTPCall. Otherwise
+	// it is decoded into an interface{}.
+	Target interface{}
+
+	args  []*template.Template
+	stdin *template.Template
+}
+
+// ExecError is the error of the response of an exec task, when the process exits with a non zero code or is killed
+type ExecError struct {
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExecError) Error() string {
+	message := fmt.Sprintf("the command has exited with the code %d", e.ExitCode)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		message += " : " + stderr
+	}
+	return message
+}
+
+// Use this method to create a task, which runs the command as a local process. The response code of the task is the
+// exit code of the process, or -1 when it could not be started. A non zero exit code fails the task with an
+// *ExecError, which has the stderr of the process.
+//
+// When the task times out or the request is cancelled, the process is killed along with the processes it has started,
+// as it runs in its own process group on unix. The processes it has started are killed when it exits too. The task
+// can not have replicas, as running a process twice is rarely safe.
+func NewExecTask(name string, command Command) (*FutureTask, error) {
+	if command.Path == "" {
+		return nil, fmt.Errorf("the path of the exec task %q is empty", name)
+	}
+	if command.Output < RawOutput || command.Output > LinesOutput {
+		return nil, fmt.Errorf("the output format of the exec task %q is unknown : %v", name, command.Output)
+	}
+
+	parse := func(part, text string) (*template.Template, error) {
+		t, err := template.New(part).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("the %s template of the exec task %q is invalid : %v", part, name, err)
+		}
+		return t, nil
+	}
+	command.args = make([]*template.Template, len(command.Args))
+	for i, arg := range command.Args {
+		t, err := parse(fmt.Sprintf("args[%d]", i), arg)
+		if err != nil {
+			return nil, err
+		}
+		command.args[i] = t
+	}
+	stdin, err := parse("stdin", command.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	command.stdin = stdin
+	return &FutureTask{Name: name, Exec: &command}, nil
+}
+
+// Runs the command for the task, the process is killed when the timeout passes or the request is cancelled
+func doExec(ch chan *Response, r *Request, task *FutureTask, bridgeConnection *BridgeConnection, timeout time.Duration, release func()) {
+	go func() {
+		defer release()
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeout != NoTimeout {
+			ctx, cancel = context.WithTimeout(r.Ctx, timeout)
+		} else {
+			ctx, cancel = context.WithCancel(r.Ctx)
+		}
+		defer cancel()
+
+		var data []interface{}
+		if bridgeConnection != nil {
+			data = bridgeConnection.Data
+		}
+		preTime := time.Now()
+		futureTaskResponse := task.Exec.run(ctx, data)
+		ch <- &Response{
+			ResponseTime: time.Since(preTime),
+			ResponseCode: futureTaskResponse.ResponseCode,
+			Data:         futureTaskResponse.Data,
+			Error:        futureTaskResponse.Error,
+		}
+	}()
+}
+
+func (c *Command) run(ctx context.Context, data []interface{}) *FutureTaskResponse {
+	fill := func(t *template.Template) (string, error) {
+		var b strings.Builder
+		err := t.Execute(&b, data)
+		return b.String(), err
+	}
+	args := make([]string, len(c.args))
+	for i, t := range c.args {
+		arg, err := fill(t)
+		if err != nil {
+			return &FutureTaskResponse{ResponseCode: -1, Error: err}
+		}
+		args[i] = arg
+	}
+	stdin, err := fill(c.stdin)
+	if err != nil {
+		return &FutureTaskResponse{ResponseCode: -1, Error: err}
+	}
+
+	// The pipes are written and read here, instead of by the command, so that Wait returns when the process exits,
+	// even if the processes it has started hold the pipes open
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return &FutureTaskResponse{ResponseCode: -1, Error: err}
+	}
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		closeFiles(stdoutReader, stdoutWriter)
+		return &FutureTaskResponse{ResponseCode: -1, Error: err}
+	}
+	var stdinReader, stdinWriter *os.File
+	if stdin != "" {
+		if stdinReader, stdinWriter, err = os.Pipe(); err != nil {
+			closeFiles(stdoutReader, stdoutWriter, stderrReader, stderrWriter)
+			return &FutureTaskResponse{ResponseCode: -1, Error: err}
+		}
+	}
+
+	cmd := exec.Command(c.Path, args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	if stdinReader != nil {
+		cmd.Stdin = stdinReader
+	}
+	setProcessGroup(cmd)
+	err = cmd.Start()
+	closeFiles(stdoutWriter, stderrWriter, stdinReader)
+	if err != nil {
+		closeFiles(stdoutReader, stderrReader, stdinWriter)
+		return &FutureTaskResponse{ResponseCode: -1, Error: err}
+	}
+
+	if stdinWriter != nil {
+		go func() {
+			io.WriteString(stdinWriter, stdin)
+			stdinWriter.Close()
+		}()
+	}
+	var stdout, stderr bytes.Buffer
+	var read sync.WaitGroup
+	read.Add(2)
+	go func() {
+		defer read.Done()
+		io.Copy(&stdout, stdoutReader)
+		stdoutReader.Close()
+	}()
+	go func() {
+		defer read.Done()
+		io.Copy(&stderr, stderrReader)
+		stderrReader.Close()
+	}()
+
+	// The whole group is killed, when the context is done or the process exits, so that the processes it has started
+	// do not linger and the pipes they hold are closed
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-exited:
+		}
+	}()
+	err = cmd.Wait()
+	close(exited)
+	killProcessGroup(cmd)
+	read.Wait()
+
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return &FutureTaskResponse{ResponseCode: -1, Error: err}
+		}
+		return &FutureTaskResponse{ResponseCode: exitErr.ExitCode(), Error: &ExecError{ExitCode: exitErr.ExitCode(), Stderr: stderr.String()}}
+	}
+
+	output, err := c.parse(stdout.Bytes())
+	if err != nil {
+		return &FutureTaskResponse{ResponseCode: 0, Error: err}
+	}
+	return &FutureTaskResponse{ResponseCode: 0, Data: output}
+}
+
+// Closes the files, which are not nil
+func closeFiles(files ...*os.File) {
+	for _, file := range files {
+		if file != nil {
+			file.Close()
+		}
+	}
+}
+
+// Parses the stdout as per the output format
+func (c *Command) parse(stdout []byte) (interface{}, error) {
+	switch c.Output {
+	case JSONOutput:
+		value, err := decodeJSON(stdout, c.Target)
+		if err != nil {
+			return nil, fmt.Errorf("the output can not be decoded : %v", err)
+		}
+		return value, nil
+	case LinesOutput:
+		text := strings.TrimSuffix(strings.Replace(string(stdout), "\r\n", "\n", -1), "\n")
+		if text == "" {
+			return []string{}, nil
+		}
+		return strings.Split(text, "\n"), nil
+	}
+	return string(stdout), nil
+}
@@ -0,0 +1,14 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package rio
+
+import "os/exec"
+
+// There are no process groups to start the process in, the processes it starts are not killed with it
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// Kills the process only, it does nothing when the process has exited
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,124 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package rio
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecTaskOutputs(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	raw, _ := NewExecTask("Echo", Command{Path: "echo", Args: []string{"hello", "{{index . 0}}"}})
+	lines, _ := NewExecTask("Sort", Command{Path: "sort", Stdin: "{{range .}}{{.}}\n{{end}}", Output: LinesOutput})
+	decoded, _ := NewExecTask("User", Command{
+		Path:   "sh",
+		Args:   []string{"-c", `echo "{\"id\": \"$1\", \"name\": \"rio\"}"`, "sh", "{{index . 0}}"},
+		Output: JSONOutput,
+		Target: &testUser{},
+	})
+	request := BuildRequests(context.Background(), raw).WithInput("world").
+		FollowedBy(func(interface{}) *BridgeConnection {
+			return &BridgeConnection{Data: []interface{}{"b", "c", "a"}}
+		}, lines).
+		FollowedBy(func(data interface{}) *BridgeConnection {
+			return &BridgeConnection{Data: []interface{}{data.([]string)[0]}}
+		}, decoded)
+
+	future, _ := balancer.Submit(request)
+	responses, err := future.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].Data != "hello world\n" || !reflect.DeepEqual(responses[1].Data, []string{"a", "b", "c"}) {
+		t.Errorf("unexpected responses : %v, %v", responses[0].Data, responses[1].Data)
+	}
+	if user, ok := responses[2].Data.(*testUser); !ok || user.ID != "a" || user.Name != "rio" || responses[2].ResponseCode != 0 {
+		t.Errorf("unexpected response : %+v", responses[2])
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestExecTaskFailures(t *testing.T) {
+	failing, _ := NewExecTask("Fail", Command{Path: "sh", Args: []string{"-c", "echo broken >&2; exit 3"}})
+	response := failing.Exec.run(context.Background(), nil)
+	if execErr, ok := response.Error.(*ExecError); !ok || response.ResponseCode != 3 || execErr.ExitCode != 3 ||
+		!strings.Contains(execErr.Error(), "broken") {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	missing, _ := NewExecTask("Missing", Command{Path: "rio-no-such-command"})
+	if response = missing.Exec.run(context.Background(), nil); response.Error == nil || response.ResponseCode != -1 {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	invalid, _ := NewExecTask("Invalid", Command{Path: "echo", Output: JSONOutput})
+	if response = invalid.Exec.run(context.Background(), nil); response.Error == nil {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	if _, err := NewExecTask("Broken", Command{Path: "echo", Args: []string{"{{index . 0"}}); err == nil {
+		t.Error("the invalid template is accepted")
+	}
+	if _, err := NewExecTask("Unknown", Command{Path: "echo", Output: OutputFormat(7)}); err == nil {
+		t.Error("the unknown output format is accepted")
+	}
+	if err := (&FutureTask{Name: "Bare", Exec: &Command{Path: "echo"}}).validate(); err == nil {
+		t.Error("the command not created by NewExecTask is accepted")
+	}
+	replicated, _ := NewExecTask("Replicated", Command{Path: "echo"})
+	if err := BuildRequests(context.Background(), replicated.WithReplica(2)).Validate(); err == nil {
+		t.Error("the replicas of the exec task are accepted")
+	}
+}
+
+func TestExecTaskKillsProcessGroupOnTimeout(t *testing.T) {
+	// The background sleep keeps the stdout open, so the run returns only when the whole group is killed
+	task, _ := NewExecTask("Sleep", Command{Path: "sh", Args: []string{"-c", "sleep 30 & wait"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	response := task.Exec.run(ctx, nil)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the process group is not killed, the run took %v", elapsed)
+	}
+	if _, ok := response.Error.(*ExecError); !ok {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	// Through the balancer, the task times out as any other task
+	balancer := GetBalancer(1, 1)
+	future, _ := balancer.Submit(BuildRequests(context.Background(), task.WithMilliSecondTimeout(200)))
+	if _, err := future.Wait(context.Background()); err != ErrTimeout {
+		t.Errorf("unexpected error : %v", err)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestExecTaskKillsProcessGroupOnExit(t *testing.T) {
+	// The background sleep keeps the stdout open after the shell exits, there is no timeout to kill it
+	task, _ := NewExecTask("Detach", Command{Path: "sh", Args: []string{"-c", "sleep 30 & echo started"}})
+
+	done := make(chan *FutureTaskResponse, 1)
+	go func() { done <- task.Exec.run(context.Background(), nil) }()
+	select {
+	case response := <-done:
+		if response.Error != nil || response.Data != "started\n" {
+			t.Errorf("unexpected response : %+v", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run waits for the processes started by the command")
+	}
+}
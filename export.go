@@ -0,0 +1,386 @@
+package rio
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The graph of a request or a pipeline, which is rendered as DOT or Mermaid
+type graph struct {
+	name  string
+	nodes []*graphNode
+	edges []*graphEdge
+}
+
+type graphNode struct {
+	id     string
+	lines  []string
+	branch bool
+
+	// The status of the response overlaid on the node, like ok or failed, it is empty when there is no response
+	status string
+}
+
+type graphEdge struct {
+	from, to string
+	label    string
+	dashed   bool
+}
+
+// The fill colors of the nodes by the status of their responses
+var statusColors = map[string]string{
+	"ok":       "#d4edda",
+	"failed":   "#f8d7da",
+	"fallback": "#fff3cd",
+	"skipped":  "#e2e3e5",
+}
+
+// Use this method to render the pipeline as a Graphviz DOT graph, with the tasks, their timeouts, retries and replicas
+// and the bridges between them
+func (p *Pipeline) DOT() string {
+	return p.graph(nil).dot()
+}
+
+// Use this method to render the pipeline as a Mermaid flowchart, like DOT
+func (p *Pipeline) Mermaid() string {
+	return p.graph(nil).mermaid()
+}
+
+// Use this method to render the request as a Graphviz DOT graph. When the request is completed, the latency and the
+// status of its responses are overlaid on the tasks. Do not call it while the request is being processed.
+func (r *Request) DOT() string {
+	return r.graph().dot()
+}
+
+// Use this method to render the request as a Mermaid flowchart, like DOT
+func (r *Request) Mermaid() string {
+	return r.graph().mermaid()
+}
+
+// Use this method to render the pipeline definition as a Graphviz DOT graph, without building it
+func (p *PipelineDefinition) DOT() string {
+	return p.graph().dot()
+}
+
+// Use this method to render the pipeline definition as a Mermaid flowchart, without building it
+func (p *PipelineDefinition) Mermaid() string {
+	return p.graph().mermaid()
+}
+
+func (r *Request) graph() *graph {
+	if r.pipeline != nil {
+		g := r.pipeline.graph(r.Responses)
+		g.name = r.ID
+		return g
+	}
+	p := &Pipeline{tasks: r.Tasks, bridges: r.Bridges, historyBridges: r.HistoryBridges}
+	g := p.graph(r.Responses)
+	g.name = r.ID
+	return g
+}
+
+func (p *Pipeline) graph(responses []*Response) *graph {
+	g := &graph{name: p.name}
+	var labels []string
+	if p.definition != nil {
+		_, edges := p.definition.chain()
+		for _, edge := range edges {
+			labels = append(labels, edge.label())
+		}
+	}
+
+	var exits []string
+	for i, task := range p.tasks {
+		label := ""
+		if i > 0 {
+			switch {
+			case i-1 < len(labels):
+				label = labels[i-1]
+			case i-1 < len(p.historyBridges) && p.historyBridges[i-1] != nil:
+				label = "history: " + funcName(p.historyBridges[i-1])
+			case i-1 < len(p.bridges) && p.bridges[i-1] != nil:
+				label = funcName(p.bridges[i-1])
+			}
+		}
+		exits = g.step(task, responseAt(responses, i), exits, label)
+	}
+	return g
+}
+
+func (p *PipelineDefinition) graph() *graph {
+	g := &graph{name: p.Name}
+	order, edges := p.chain()
+	var exits []string
+	for i, index := range order {
+		task := p.Tasks[index]
+		node := g.node(task.Name)
+		if task.Pipeline != "" {
+			node.lines = append(node.lines, "pipeline "+task.Pipeline)
+		} else if task.Callback != "" && task.Callback != task.Name {
+			node.lines = append(node.lines, "callback "+task.Callback)
+		}
+		node.lines = append(node.lines, settings(task.Timeout, task.Retries, task.Replicas, task.Bulkhead)...)
+		for _, exit := range exits {
+			g.edges = append(g.edges, &graphEdge{from: exit, to: node.id, label: edges[i-1].label()})
+		}
+		exits = []string{node.id}
+	}
+	return g
+}
+
+// Adds the nodes of the step, connected from the exits of the previous step, and returns its exits
+func (g *graph) step(task *FutureTask, response *Response, from []string, label string) []string {
+	if task == nil {
+		return from
+	}
+	if task.Predicate != nil {
+		return g.branchStep(task, response, from, label)
+	}
+
+	node := g.node(taskName(task, len(g.nodes)))
+	switch {
+	case task.ForEach != nil:
+		node.lines = append(node.lines, fmt.Sprintf("for each %s", taskName(task.ForEach.Task, len(g.nodes)-1)))
+		if task.ForEach.Parallelism > 0 {
+			node.lines = append(node.lines, fmt.Sprintf("parallelism %d", task.ForEach.Parallelism))
+		}
+		if task.ForEach.Task != nil {
+			node.lines = append(node.lines, settings(task.ForEach.Task.Timeout, task.ForEach.Task.RetryCount, task.ForEach.Task.ReplicaCount, task.ForEach.Task.Bulkhead)...)
+		}
+		if response != nil {
+			failed := 0
+			for _, item := range response.Nested {
+				if item.Error != nil {
+					failed++
+				}
+			}
+			node.lines = append(node.lines, fmt.Sprintf("%d items, %d failed", len(response.Nested), failed))
+		}
+	case task.Scatter != nil:
+		line := fmt.Sprintf("scatter gather %s of %d", task.Scatter.Mode, len(task.Scatter.Callbacks))
+		if task.Scatter.Mode == Quorum {
+			line = fmt.Sprintf("scatter gather quorum %d of %d", task.Scatter.required(), len(task.Scatter.Callbacks))
+		}
+		node.lines = append(node.lines, line)
+	case task.Pipeline != nil:
+		node.lines = append(node.lines, "pipeline "+task.Pipeline.Name())
+	case task.Exec != nil:
+		node.lines = append(node.lines, "exec "+task.Exec.Path)
+	case task.HTTP != nil:
+		node.lines = append(node.lines, "http "+task.HTTP.Method+" "+task.HTTP.URL)
+	}
+	node.lines = append(node.lines, settings(task.Timeout, task.RetryCount, task.ReplicaCount, task.Bulkhead)...)
+	g.overlay(node, response)
+
+	for _, exit := range from {
+		g.edges = append(g.edges, &graphEdge{from: exit, to: node.id, label: label})
+	}
+	if task.Fallback != nil {
+		fallback := g.step(task.Fallback, nil, nil, "")
+		g.edges = append(g.edges, &graphEdge{from: node.id, to: fallback[0], label: "fallback", dashed: true})
+	}
+	return []string{node.id}
+}
+
+// Adds the branch node and the chains of its branches, the exits are the last tasks of all the branches
+func (g *graph) branchStep(task *FutureTask, response *Response, from []string, label string) []string {
+	node := g.node("branch")
+	node.branch = true
+	g.overlay(node, response)
+	for _, exit := range from {
+		g.edges = append(g.edges, &graphEdge{from: exit, to: node.id, label: label})
+	}
+
+	var exits []string
+	position := 0
+	for _, branch := range task.Branches {
+		if branch == nil {
+			continue
+		}
+		last := []string{node.id}
+		for i, t := range branch.Tasks {
+			label := ""
+			if i < len(branch.Bridges) && branch.Bridges[i] != nil {
+				label = funcName(branch.Bridges[i])
+			}
+			if i == 0 {
+				label = strings.TrimSuffix(branch.Name+": "+label, ": ")
+			}
+			var nested *Response
+			if response != nil {
+				nested = responseAt(response.Nested, position)
+			}
+			position++
+			last = g.step(t, nested, last, label)
+		}
+		exits = append(exits, last...)
+	}
+	return exits
+}
+
+func (g *graph) node(name string) *graphNode {
+	node := &graphNode{id: fmt.Sprintf("t%d", len(g.nodes)), lines: []string{name}}
+	g.nodes = append(g.nodes, node)
+	return node
+}
+
+// Adds the status and the latency of the response to the node
+func (g *graph) overlay(node *graphNode, response *Response) {
+	switch {
+	case response == nil:
+		return
+	case response.Skipped:
+		node.status = "skipped"
+		node.lines = append(node.lines, "skipped")
+		return
+	case response.Error != nil:
+		node.status = "failed"
+		node.lines = append(node.lines, "failed: "+response.Error.Error())
+	case response.Fallback:
+		node.status = "fallback"
+		node.lines = append(node.lines, "fallback")
+	default:
+		node.status = "ok"
+	}
+	if response.ResponseTime >= 0 {
+		node.lines = append(node.lines, fmt.Sprintf("code %d in %v", response.ResponseCode, response.ResponseTime.Round(time.Microsecond)))
+	}
+}
+
+func (g *graph) dot() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(g.name))
+	b.WriteString("  rankdir=LR;\n  node [shape=box];\n")
+	for _, node := range g.nodes {
+		attributes := []string{"label=" + dotQuote(strings.Join(node.lines, "\n"))}
+		if node.branch {
+			attributes = append(attributes, "shape=diamond")
+		}
+		if color, ok := statusColors[node.status]; ok {
+			attributes = append(attributes, "style=filled", "fillcolor="+dotQuote(color))
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", node.id, strings.Join(attributes, ", "))
+	}
+	for _, edge := range g.edges {
+		var attributes []string
+		if edge.label != "" {
+			attributes = append(attributes, "label="+dotQuote(edge.label))
+		}
+		if edge.dashed {
+			attributes = append(attributes, "style=dashed")
+		}
+		if len(attributes) > 0 {
+			fmt.Fprintf(&b, "  %s -> %s [%s];\n", edge.from, edge.to, strings.Join(attributes, ", "))
+		} else {
+			fmt.Fprintf(&b, "  %s -> %s;\n", edge.from, edge.to)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (g *graph) mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, node := range g.nodes {
+		text := mermaidQuote(strings.Join(node.lines, "<br/>"))
+		if node.branch {
+			fmt.Fprintf(&b, "  %s{%s}\n", node.id, text)
+		} else {
+			fmt.Fprintf(&b, "  %s[%s]\n", node.id, text)
+		}
+	}
+	for _, edge := range g.edges {
+		arrow := "-->"
+		if edge.dashed {
+			arrow = "-.->"
+		}
+		if edge.label != "" {
+			fmt.Fprintf(&b, "  %s %s|%s| %s\n", edge.from, arrow, mermaidQuote(edge.label), edge.to)
+		} else {
+			fmt.Fprintf(&b, "  %s %s %s\n", edge.from, arrow, edge.to)
+		}
+	}
+	for _, status := range []string{"ok", "failed", "fallback", "skipped"} {
+		var ids []string
+		for _, node := range g.nodes {
+			if node.status == status {
+				ids = append(ids, node.id)
+			}
+		}
+		if len(ids) > 0 {
+			fmt.Fprintf(&b, "  classDef %s fill:%s\n  class %s %s\n", status, statusColors[status], strings.Join(ids, ","), status)
+		}
+	}
+	return b.String()
+}
+
+// The lines of the task settings, which are set
+func settings(timeout time.Duration, retries, replicas int, bulkhead string) []string {
+	var lines []string
+	switch {
+	case timeout == NoTimeout:
+		lines = append(lines, "no timeout")
+	case timeout > 0:
+		lines = append(lines, fmt.Sprintf("timeout %v", timeout))
+	}
+	switch {
+	case retries == NoRetries:
+		lines = append(lines, "no retries")
+	case retries > 0:
+		lines = append(lines, fmt.Sprintf("retries %d", retries))
+	}
+	if replicas > 1 {
+		lines = append(lines, fmt.Sprintf("replicas %d", replicas))
+	}
+	if bulkhead != "" {
+		lines = append(lines, "bulkhead "+bulkhead)
+	}
+	return lines
+}
+
+// The label of the edge, the name of its bridge
+func (e *EdgeDefinition) label() string {
+	if e.HistoryBridge != "" {
+		return "history: " + e.HistoryBridge
+	}
+	return e.Bridge
+}
+
+func taskName(task *FutureTask, index int) string {
+	if task == nil || task.Name == "" {
+		return fmt.Sprintf("task %d", index)
+	}
+	return task.Name
+}
+
+func responseAt(responses []*Response, index int) *Response {
+	if index < len(responses) {
+		return responses[index]
+	}
+	return nil
+}
+
+// The name of the function, like rio.passBridge, to label the bridges built in code
+func funcName(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return ""
+	}
+	name := f.Name()
+	if slash := strings.LastIndex(name, "/"); slash >= 0 {
+		name = name[slash+1:]
+	}
+	return name
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, "#quot;", -1) + `"`
+}
@@ -0,0 +1,104 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExportDefinition(t *testing.T) {
+	definition, err := ParsePipelineDefinition([]byte(testPipelineYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := definition.DOT()
+	for _, expected := range []string{
+		`digraph "Profile" {`,
+		`t0 [label="GetUser\ncallback user\ntimeout 100ms\nretries 2"];`,
+		`t1 [label="GetAvatar\ntimeout 50ms\nreplicas 2"];`,
+		`t2 [label="Render\npipeline Page"];`,
+		`t0 -> t1 [label="pass"];`,
+		`t1 -> t2 [label="history: both"];`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("%q is not in\n%s", expected, dot)
+		}
+	}
+
+	mermaid := definition.Mermaid()
+	for _, expected := range []string{
+		"flowchart LR",
+		`t0["GetUser<br/>callback user<br/>timeout 100ms<br/>retries 2"]`,
+		`t1 -->|"history: both"| t2`,
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("%q is not in\n%s", expected, mermaid)
+		}
+	}
+
+	// The built pipeline keeps the bridge names of its definition
+	pipeline, err := testRegistry().ParsePipeline([]byte(testPipelineYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dot = pipeline.DOT(); !strings.Contains(dot, `t0 -> t1 [label="pass"];`) || !strings.Contains(dot, "pipeline Page") {
+		t.Errorf("unexpected graph\n%s", dot)
+	}
+}
+
+func TestExportRequestWithResults(t *testing.T) {
+	lookup := NewNamedFutureTask("Lookup", valueTask(nil, errors.New("not cached"))).WithSecondTimeout(1)
+	request := BuildRequests(context.Background(), lookup).
+		Branch(cacheBranch,
+			NewBranch("hit", passBridge, NewNamedFutureTask("Cached", echoTask("cached "))),
+			NewBranch("miss", passBridge, NewNamedFutureTask("Load", echoTask("loaded ")).WithRetry(3))).
+		FollowedBy(passBridge, NewNamedFutureTask("Store", valueTask(nil, errors.New("down"))).
+			WithFallback(NewNamedFutureTask("Queue", valueTask("queued", nil))))
+
+	// Before the request is run, there are no results
+	if dot := request.DOT(); strings.Contains(dot, "fillcolor") ||
+		!strings.Contains(dot, `t1 [label="branch", shape=diamond];`) ||
+		!strings.Contains(dot, `t1 -> t2 [label="hit: rio.passBridge"];`) ||
+		!strings.Contains(dot, `t3 [label="Load\nretries 3"];`) ||
+		!strings.Contains(dot, `t2 -> t4 [label="rio.passBridge"];`) ||
+		!strings.Contains(dot, `t3 -> t4 [label="rio.passBridge"];`) ||
+		!strings.Contains(dot, `t4 -> t5 [label="fallback", style=dashed];`) {
+		t.Errorf("unexpected graph\n%s", dot)
+	}
+
+	balancer := GetBalancer(1, 1)
+	future, _ := balancer.Submit(request)
+	if _, err := future.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	dot := request.DOT()
+	for _, expected := range []string{
+		`t0 [label="Lookup\ntimeout 1s\nfailed: not cached\ncode 200 in `,
+		`t2 [label="Cached\nskipped", style=filled, fillcolor="#e2e3e5"];`,
+		`t4 [label="Store\nfallback\ncode 200 in `,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("%q is not in\n%s", expected, dot)
+		}
+	}
+	mermaid := request.Mermaid()
+	for _, expected := range []string{"class t0 failed", "class t1,t3 ok", "class t4 fallback", "class t2 skipped"} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("%q is not in\n%s", expected, mermaid)
+		}
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestExportUnvalidatedRequest(t *testing.T) {
+	// The request has no bridge for its second task, it is drawn without the label instead of panicking
+	request := &Request{Tasks: []*FutureTask{NewNamedFutureTask("First", Task1), NewNamedFutureTask("Second", Task2)}}
+	if dot := request.DOT(); !strings.Contains(dot, "t0 -> t1;") {
+		t.Errorf("unexpected graph\n%s", dot)
+	}
+}
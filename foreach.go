@@ -0,0 +1,141 @@
+package rio
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// FanOut is the bridge of a fan out step. It converts the data of the previous response, like a list of ids, to a
+// bridge connection for each item.
+type FanOut func(interface{}) ([]*BridgeConnection, error)
+
+// Reducer aggregates the responses of the items of a fan out step, in the order of the items, into the response of
+// the step
+type Reducer func([]*Response) *FutureTaskResponse
+
+// ForEach is a fan out step, which runs the task once for each bridge connection yielded by the fan out, see
+// Request.ForEach
+type ForEach struct {
+	FanOut FanOut
+	Task   *FutureTask
+
+	// The maximum number of the items run concurrently, 0 runs all of them at once
+	Parallelism int
+
+	// The reducer of the item responses, CollectData is used when it is nil
+	Reducer Reducer
+}
+
+// This construct is used to call a task once for each item of the previous response. The fan out converts the data
+// of the previous response to a bridge connection per item, and the task is run for the items concurrently, at most
+// parallelism of them at a time, 0 for no limit. Each item is run like a task of its own, with the retries, the
+// fallback and the timeout of the task.
+//
+// The fan out step has a single response in the Responses of the request, its data is reduced from the responses of
+// the items by the reducer, CollectData if it is nil. The responses of the items are kept in the nested responses, in
+// the order of the items, along with their errors.
+func (r *Request) ForEach(fanOut FanOut, task *FutureTask, parallelism int, reducer Reducer) *Request {
+	r.Tasks = append(r.Tasks, &FutureTask{ForEach: &ForEach{FanOut: fanOut, Task: task, Parallelism: parallelism, Reducer: reducer}})
+	if len(r.Tasks) > 1 {
+		// The fan out is the bridge of the step, the nil slot keeps the tasks matched with their bridges
+		r.Bridges = append(r.Bridges, nil)
+	}
+	return r
+}
+
+// The default reducer, the data is a []interface{} with the data of each item, nil for the failed ones. It fails
+// only when all the items have failed, with the error of the first one.
+func CollectData(responses []*Response) *FutureTaskResponse {
+	data := make([]interface{}, len(responses))
+	var err error
+	failed := 0
+	for i, response := range responses {
+		if response.Error != nil {
+			if err == nil {
+				err = response.Error
+			}
+			failed++
+			continue
+		}
+		data[i] = response.Data
+	}
+	if failed > 0 && failed == len(responses) {
+		return &FutureTaskResponse{ResponseCode: -1, Data: data, Error: err}
+	}
+	return &FutureTaskResponse{ResponseCode: 200, Data: data}
+}
+
+func (f *ForEach) validate() error {
+	switch {
+	case f.FanOut == nil:
+		return errors.New("the fan out of the fan out step is nil")
+	case f.Parallelism < 0:
+		return errors.New("the parallelism of the fan out step is negative")
+	}
+	if err := f.Task.validate(); err != nil {
+		return errors.New("the task of the fan out step : " + err.Error())
+	}
+	// The item task is run like a plain task, a step has no callback to run
+	if f.Task.ownsBridge() {
+		return errors.New("the task of the fan out step can not be a branch or a fan out step")
+	}
+	return nil
+}
+
+// This method runs the task of the fan out step for each item, see Request.ForEach
+func (w *Worker) forEach(r *Request, index, remaining int, task *FutureTask, parent *Response) (*Response, bool, error) {
+	step := task.ForEach
+	connections, err := step.FanOut(parent.Data)
+	if err != nil {
+		return bridgeError(err), true, nil
+	}
+
+	start := time.Now()
+	responses := make([]*Response, len(connections))
+	parallelism := step.Parallelism
+	if parallelism == 0 || parallelism > len(connections) {
+		parallelism = len(connections)
+	}
+
+	// Each item is run on its own goroutine, the slots channel caps how many of them run at a time
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, connection := range connections {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, connection *BridgeConnection) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			response, attempt, err := w.run(r, index, remaining, step.Task, connection)
+			if err != nil {
+				response = &Response{ResponseCode: -1, Error: err}
+			}
+			responses[i] = response
+			r.itemProgress(index, i, step.Task, attempt, response)
+		}(i, connection)
+	}
+	wg.Wait()
+
+	// The items stopped by the request context do not make a result
+	if err := r.Ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	reducer := step.Reducer
+	if reducer == nil {
+		reducer = CollectData
+	}
+	reduced := reducer(responses)
+	response := &Response{
+		ResponseTime: time.Since(start),
+		ResponseCode: reduced.ResponseCode,
+		Data:         reduced.Data,
+		Error:        reduced.Error,
+		Nested:       responses,
+	}
+	r.progress(TaskCompleted, index, task, 1, response)
+	return response, false, nil
+}
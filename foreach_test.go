@@ -0,0 +1,133 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func idsFanOut(data interface{}) ([]*BridgeConnection, error) {
+	ids, ok := data.([]string)
+	if !ok {
+		return nil, errors.New("expected the ids")
+	}
+	connections := make([]*BridgeConnection, 0, len(ids))
+	for _, id := range ids {
+		connections = append(connections, &BridgeConnection{Data: []interface{}{id}})
+	}
+	return connections, nil
+}
+
+func TestForEachWithParallelism(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	var running, maxRunning int32
+	lookup := func(bconn *BridgeConnection) *FutureTaskResponse {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		id := bconn.Data[0].(string)
+		if id == "bad" {
+			return &FutureTaskResponse{ResponseCode: 404, Error: errors.New("not found")}
+		}
+		return &FutureTaskResponse{ResponseCode: 200, Data: "user " + id}
+	}
+
+	request := BuildRequests(context.Background(), NewFutureTask(valueTask([]string{"1", "bad", "3", "4", "5"}, nil))).
+		ForEach(idsFanOut, NewNamedFutureTask("Lookup", lookup), 2, nil).
+		FollowedBy(func(data interface{}) *BridgeConnection {
+			return &BridgeConnection{Data: []interface{}{len(data.([]interface{}))}}
+		}, NewFutureTask(func(bconn *BridgeConnection) *FutureTaskResponse {
+			return &FutureTaskResponse{Data: bconn.Data[0]}
+		}))
+
+	future, _ := balancer.Submit(request)
+	responses, err := future.Wait(context.Background())
+	if err != nil || len(responses) != 3 {
+		t.Fatalf("unexpected result : %v", err)
+	}
+	data := responses[1].Data.([]interface{})
+	nested := responses[1].Nested
+	if responses[1].Error != nil || data[0] != "user 1" || data[1] != nil || data[4] != "user 5" || responses[2].Data != 5 {
+		t.Errorf("unexpected data : %v", data)
+	}
+	if len(nested) != 5 || nested[1].Error == nil || nested[1].ResponseCode != 404 || nested[2].Data != "user 3" {
+		t.Fail()
+	}
+	if maxRunning != 2 {
+		t.Errorf("unexpected parallelism : %d", maxRunning)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestForEachReducerAndFanOutError(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	count := func(responses []*Response) *FutureTaskResponse {
+		return &FutureTaskResponse{ResponseCode: 200, Data: len(responses)}
+	}
+	future, _ := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(valueTask([]string{"1", "2"}, nil))).
+		ForEach(idsFanOut, NewFutureTask(echoTask("user ")), 0, count))
+	if responses, err := future.Wait(context.Background()); err != nil || responses[1].Data != 2 {
+		t.Fail()
+	}
+
+	// A failed fan out breaks the chain like a failed bridge
+	future, _ = balancer.Submit(BuildRequests(context.Background(), NewFutureTask(valueTask("not ids", nil))).
+		ForEach(idsFanOut, NewFutureTask(echoTask("user ")), 0, nil).
+		FollowedBy(passBridge, NewFutureTask(echoTask("got "))))
+	responses, err := future.Wait(context.Background())
+	if err != nil || len(responses) != 3 || responses[1].Error == nil || responses[2].Error == nil {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestForEachValidation(t *testing.T) {
+	first := func() *Request {
+		return BuildRequests(context.Background(), NewFutureTask(valueTask("user", nil)))
+	}
+	invalid := map[string]*Request{
+		"needs a task before it":             (&Request{Ctx: context.Background()}).ForEach(idsFanOut, NewFutureTask(Task1), 0, nil),
+		"fan out of the fan out step is nil": first().ForEach(nil, NewFutureTask(Task1), 0, nil),
+		"parallelism":                        first().ForEach(idsFanOut, NewFutureTask(Task1), -1, nil),
+		"the task is nil":                    first().ForEach(idsFanOut, nil, 0, nil),
+	}
+	for message, request := range invalid {
+		if err := request.Validate(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s : unexpected error %v", message, err)
+		}
+	}
+
+	// The steps have no callback, so the balancer must not accept them as the item task
+	balancer := GetBalancer(1, 1)
+	steps := []*FutureTask{
+		{ForEach: &ForEach{FanOut: idsFanOut, Task: NewFutureTask(Task1)}},
+		{Predicate: cacheBranch, Branches: []*Branch{NewBranch("hit", passBridge, NewFutureTask(Task1))}},
+	}
+	for _, step := range steps {
+		_, err := balancer.Submit(first().ForEach(idsFanOut, step, 0, nil))
+		if err == nil || !strings.Contains(err.Error(), "can not be a branch or a fan out step") {
+			t.Errorf("unexpected error : %v", err)
+		}
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
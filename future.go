@@ -0,0 +1,94 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// This error is returned by Future.Result, when the request is not completed yet
+var ErrNotCompleted = errors.New("the request is not completed yet")
+
+// Future is the handle of a posted request, which completes when the request is processed. Use it to wait for the
+// request, to poll it or to register the completion callbacks, instead of reading the CompletedChannel.
+type Future struct {
+	request *Request
+	done    chan struct{}
+
+	// The error of the request, as a whole. It is nil when all the tasks are run, even when some of them failed, the
+	// task failures are available in the responses.
+	err error
+
+	mu        sync.Mutex
+	completed bool
+	callbacks []func(*Request)
+}
+
+func newFuture(r *Request) *Future {
+	return &Future{request: r, done: make(chan struct{})}
+}
+
+// The request, which this future belongs to
+func (f *Future) Request() *Request {
+	return f.request
+}
+
+// The channel is closed, when the request is completed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Use this method to wait for the request to complete, or for the context to be done, whichever happens first
+func (f *Future) Wait(ctx context.Context) ([]*Response, error) {
+	select {
+	case <-f.done:
+		return f.Result()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Use this method to get the responses without waiting. If the request is not completed yet, ErrNotCompleted is
+// returned. Otherwise the error tells, if the request is stopped before all its tasks are run, like on a timeout or
+// a context cancellation.
+func (f *Future) Result() ([]*Response, error) {
+	select {
+	case <-f.done:
+		return f.request.Responses, f.err
+	default:
+		return nil, ErrNotCompleted
+	}
+}
+
+// Use this method to register a callback, which is called with the request when it completes. The callbacks are
+// called in a separate goroutine, so they never block the worker. If the request is already completed, the callback
+// is called immediately.
+func (f *Future) OnComplete(callback func(*Request)) {
+	f.mu.Lock()
+	if !f.completed {
+		f.callbacks = append(f.callbacks, callback)
+		f.mu.Unlock()
+		return
+	}
+	f.mu.Unlock()
+	callback(f.request)
+}
+
+// Completes the future with the error, it is called only once per request
+func (f *Future) complete(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.completed = true
+	callbacks := f.callbacks
+	f.callbacks = nil
+	close(f.done)
+	f.mu.Unlock()
+
+	if len(callbacks) > 0 {
+		go func() {
+			for _, callback := range callbacks {
+				callback(f.request)
+			}
+		}()
+	}
+}
@@ -0,0 +1,128 @@
+package rio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFutureWaitWithoutCompletedChannel(t *testing.T) {
+	balancer := GetBalancer(2, 2)
+
+	request := &Request{
+		Tasks:   []*FutureTask{NewFutureTask(Task1).WithSecondTimeout(10), NewFutureTask(Task6).WithSecondTimeout(10)},
+		Bridges: []Bridge{Bridge5},
+		Ctx:     context.Background(),
+	}
+	future, err := balancer.Submit(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses, err := future.Wait(context.Background())
+	if err != nil || len(responses) != 2 || responses[0].Data.(string) != "Response 1" {
+		t.Fail()
+	}
+	if future.Request() != request {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestFutureResultAndOnComplete(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	release := make(chan bool)
+	request := BuildRequests(context.Background(), NewFutureTask(blockingTask(release)).WithSecondTimeout(10))
+	future, _ := balancer.Submit(request)
+
+	if _, err := future.Result(); err != ErrNotCompleted {
+		t.Fail()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := future.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fail()
+	}
+
+	notified := make(chan *Request, 2)
+	future.OnComplete(func(r *Request) { notified <- r })
+	close(release)
+
+	<-future.Done()
+	if (<-notified) != request {
+		t.Fail()
+	}
+
+	// A callback registered after the completion is called immediately
+	future.OnComplete(func(r *Request) { notified <- r })
+	if len(notified) != 1 {
+		t.Fail()
+	}
+
+	// Nobody reads the CompletedChannel, it must not block the worker for the next request
+	second, _ := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(Task4).WithSecondTimeout(10)))
+	if responses, err := second.Wait(context.Background()); err != nil || responses[0].Data.(string) != "Response 4" {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestFutureWithTimedOutTask(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	release := make(chan bool)
+	defer close(release)
+	future, _ := balancer.Submit(BuildRequests(context.Background(),
+		NewFutureTask(blockingTask(release)).WithMilliSecondTimeout(50)))
+
+	if responses, err := future.Wait(context.Background()); err != ErrTimeout || len(responses) != 0 {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestUnbufferedCompletedChannelIsAlwaysNotified(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	// The channel is read after the caller context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	request := BuildRequests(ctx, NewFutureTask(Task1).WithSecondTimeout(10))
+	request.CompletedChannel = make(chan bool)
+	future, _ := balancer.Submit(request)
+	future.Wait(context.Background())
+	cancel()
+	select {
+	case <-request.CompletedChannel:
+	case <-time.After(time.Second):
+		t.Error("the channel is not notified after the caller context is done")
+	}
+
+	// And long after the completion, any number of times
+	request = BuildRequests(context.Background(), NewFutureTask(Task1).WithSecondTimeout(10))
+	request.CompletedChannel = make(chan bool)
+	future, _ = balancer.Submit(request)
+	future.Wait(context.Background())
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-request.CompletedChannel:
+		case <-time.After(time.Second):
+			t.Error("the channel is not notified for a late reader")
+		}
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
@@ -0,0 +1,63 @@
+package rio
+
+// History is what a history bridge gets, the input of the request and the responses of the tasks run before
+type History struct {
+	Input     []interface{}
+	Responses []*Response
+
+	byName map[string]*Response
+}
+
+// HistoryBridge is like a Bridge, which can take from all the earlier responses and the input of the request, not only
+// the previous response
+type HistoryBridge func(*History) *BridgeConnection
+
+// The response of the task at the index in the request, it is nil when the task is not run yet
+func (h *History) Response(index int) *Response {
+	if index < 0 || index >= len(h.Responses) {
+		return nil
+	}
+	return h.Responses[index]
+}
+
+// The response of the task with the name, the last one, if there are more than one with the name. It is nil when
+// there is no such task run yet.
+func (h *History) Named(name string) *Response {
+	return h.byName[name]
+}
+
+// This construct is used like FollowedBy, when the task needs the data from an earlier task than the previous one, or
+// the input of the request. The history bridge gets all the responses so far, by their index and by their task name.
+// Unlike a bridge, it is called even if the previous response has no data.
+func (r *Request) FollowedByWithHistory(bridge HistoryBridge, task *FutureTask) *Request {
+	for len(r.HistoryBridges) < len(r.Bridges) {
+		r.HistoryBridges = append(r.HistoryBridges, nil)
+	}
+	r.HistoryBridges = append(r.HistoryBridges, bridge)
+	r.Bridges = append(r.Bridges, nil)
+	r.Tasks = append(r.Tasks, task)
+	return r
+}
+
+// The history bridge at the index of the bridges, it is nil when there is none
+func (r *Request) historyBridge(index int) HistoryBridge {
+	if index < 0 || index >= len(r.HistoryBridges) {
+		return nil
+	}
+	return r.HistoryBridges[index]
+}
+
+// The history of the request so far, it is called by the worker processing the request
+func (r *Request) history() *History {
+	h := &History{
+		Input:     r.Input,
+		Responses: append([]*Response(nil), r.Responses...),
+		byName:    make(map[string]*Response, len(r.Responses)),
+	}
+	for i, response := range h.Responses {
+		if name := r.Tasks[i].Name; name != "" {
+			h.byName[name] = response
+		}
+	}
+	return h
+}
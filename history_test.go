@@ -0,0 +1,48 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestHistoryBridge(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	pipeline := NewPipeline("Order", NewNamedFutureTask("GetUser", echoTask("user "))).
+		FollowedBy(passBridge, NewNamedFutureTask("GetCart", valueTask(nil, errors.New("no cart")))).
+		FollowedByWithHistory(func(h *History) *BridgeConnection {
+			// The previous task has failed without data, the bridge takes from the first one and the input
+			return &BridgeConnection{Data: []interface{}{h.Named("GetUser").Data, h.Input[0], h.Response(1).Error, h.Response(2)}}
+		}, NewNamedFutureTask("Checkout", func(bconn *BridgeConnection) *FutureTaskResponse {
+			return &FutureTaskResponse{Data: fmt.Sprintf("%v|%v|%v|%v", bconn.Data...)}
+		})).
+		FollowedBy(passBridge, NewFutureTask(echoTask("done ")))
+
+	future, _ := balancer.Submit(pipeline.NewRequest(context.Background(), "42"))
+	responses, err := future.Wait(context.Background())
+	if err != nil || len(responses) != 4 {
+		t.Fatalf("unexpected result : %v", err)
+	}
+	if responses[3].Data != "done user 42|42|no cart|<nil>" {
+		t.Errorf("unexpected data : %v", responses[3].Data)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestHistoryBridgeValidation(t *testing.T) {
+	valid := BuildRequests(context.Background(), NewFutureTask(Task1)).
+		FollowedByWithHistory(func(*History) *BridgeConnection { return &BridgeConnection{} }, NewFutureTask(Task2)).
+		FollowedBy(Bridge1, NewFutureTask(Task3))
+	if err := valid.Validate(); err != nil {
+		t.Error(err)
+	}
+	invalid := BuildRequests(context.Background(), NewFutureTask(Task1)).FollowedByWithHistory(nil, NewFutureTask(Task2))
+	if err := invalid.Validate(); err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,313 @@
+package rio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// HTTPCall describes the HTTP call of a task, see NewHTTPTask. The URL, the header values and the body are templates,
+// see text/template, which are executed with the data of the bridge connection, so {{index . 0}} is the first value.
+// Besides the builtin functions, like urlquery, the templates can use path to escape a path segment and json to encode
+// a value as JSON.
+//
+//	rio.HTTPCall{
+//		Method: http.MethodPost,
+//		URL:    "http://users/api/users/{{index . 0 | path}}/avatar?size={{index . 1 | urlquery}}",
+//		Body:   `{"user": {{index . 0 | json}}}`,
+//		Target: &Avatar{},
+//	}
+type HTTPCall struct {
+	// The method of the call, default is GET
+	Method string
+
+	URL     string
+	Headers map[string]string
+
+	// The body of the call, there is none when it is empty. It is sent as application/json, unless the headers set
+	// another Content-Type.
+	Body string
+
+	// When it is set, the JSON response body is decoded into a new value of its type, which is the data of the
+	// response, like a *User for &User{}. Otherwise the data is the response body as a string.
+	Target interface{}
+
+	// The client to make the call with, default is http.DefaultClient. The call is aborted, when the task times out or
+	// the request is cancelled.
+	Client *http.Client
+
+	// Tells if the failing status code is retryable, default is RetryableStatus
+	Retryable func(status int) bool
+
+	// The most bytes of the response body read, default is 10 MiB. A larger 2xx response fails the task.
+	MaxBodySize int64
+
+	url     *template.Template
+	body    *template.Template
+	headers map[string]*template.Template
+}
+
+// HTTPError is the error of the response of an HTTP task, when the status code is not 2xx
+type HTTPError struct {
+	StatusCode int
+	Body       string
+	retryable  bool
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("the call has failed with the status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Tells if the task should be retried, see RetryableError
+func (e *HTTPError) Retryable() bool {
+	return e.retryable
+}
+
+// The default of HTTPCall.MaxBodySize
+const defaultMaxBodySize = 10 << 20
+
+// The error of a call, which is made, but its response can not be used, like when the body can not be decoded. It is
+// not retried, as the call may have made a change.
+type responseError struct {
+	error
+}
+
+func (responseError) Retryable() bool {
+	return false
+}
+
+// The default classification of the failing status codes. The request timeout, too many requests and the gateway
+// errors are retryable, the others are not, as the same call would fail again.
+func RetryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// The functions, which the templates of the HTTP calls and the commands can use besides the builtin ones
+var templateFuncs = template.FuncMap{
+	"path": func(value interface{}) string {
+		return url.PathEscape(fmt.Sprint(value))
+	},
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+// Use this method to create a task, which makes the HTTP call. The response code of the task is the status code of
+// the call, or -1 when the call could not be made or is aborted. The status codes other than 2xx fail the task with
+// an *HTTPError, which is retried only when the status code is retryable. A 2xx response, which can not be read or
+// decoded, fails the task without a retry, as the call may have made a change. It returns an error, when a template
+// can not be parsed.
+//
+// Only the tasks with an idempotent method, like GET or PUT, can have replicas, as a POST would be made more than once.
+func NewHTTPTask(name string, call HTTPCall) (*FutureTask, error) {
+	if call.Method == "" {
+		call.Method = http.MethodGet
+	}
+	if call.Client == nil {
+		call.Client = http.DefaultClient
+	}
+	if call.Retryable == nil {
+		call.Retryable = RetryableStatus
+	}
+	if call.MaxBodySize <= 0 {
+		call.MaxBodySize = defaultMaxBodySize
+	}
+	if call.URL == "" {
+		return nil, fmt.Errorf("the url of the http task %q is empty", name)
+	}
+
+	parse := func(part, text string) (*template.Template, error) {
+		t, err := template.New(part).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("the %s template of the http task %q is invalid : %v", part, name, err)
+		}
+		return t, nil
+	}
+	var err error
+	if call.url, err = parse("url", call.URL); err != nil {
+		return nil, err
+	}
+	if call.body, err = parse("body", call.Body); err != nil {
+		return nil, err
+	}
+	call.headers = make(map[string]*template.Template, len(call.Headers))
+	for header, value := range call.Headers {
+		if call.headers[header], err = parse("header "+header, value); err != nil {
+			return nil, err
+		}
+	}
+	return &FutureTask{Name: name, HTTP: &call}, nil
+}
+
+// Tells if making the call more than once has the same effect as making it once
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Makes the call for the task, the call is aborted when the timeout passes or the request is cancelled. The replicas,
+// which respond after the fastest one, are aborted too.
+func doHTTP(ch chan *Response, r *Request, task *FutureTask, bridgeConnection *BridgeConnection, timeout time.Duration, release func()) {
+	go func() {
+		defer release()
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeout != NoTimeout {
+			ctx, cancel = context.WithTimeout(r.Ctx, timeout)
+		} else {
+			ctx, cancel = context.WithCancel(r.Ctx)
+		}
+		defer cancel()
+
+		var data []interface{}
+		if bridgeConnection != nil {
+			data = bridgeConnection.Data
+		}
+		var futureTaskResponse *FutureTaskResponse
+		preTime := time.Now()
+		if task.ReplicaCount > 1 {
+			replicaChannel := make(chan *FutureTaskResponse, task.ReplicaCount)
+			for i := 0; i < task.ReplicaCount; i++ {
+				go func() { replicaChannel <- task.HTTP.call(ctx, data) }()
+			}
+			futureTaskResponse = <-replicaChannel
+		} else {
+			futureTaskResponse = task.HTTP.call(ctx, data)
+		}
+		ch <- &Response{
+			ResponseTime: time.Since(preTime),
+			ResponseCode: futureTaskResponse.ResponseCode,
+			Data:         futureTaskResponse.Data,
+			Error:        futureTaskResponse.Error,
+		}
+	}()
+}
+
+func (c *HTTPCall) call(ctx context.Context, data []interface{}) *FutureTaskResponse {
+	req, err := c.request(ctx, data)
+	if err != nil {
+		return &FutureTaskResponse{ResponseCode: -1, Error: err}
+	}
+	return c.do(req)
+}
+
+// Builds the request, filling the templates with the data
+func (c *HTTPCall) request(ctx context.Context, data []interface{}) (*http.Request, error) {
+	fill := func(t *template.Template) (string, error) {
+		var b strings.Builder
+		err := t.Execute(&b, data)
+		return b.String(), err
+	}
+
+	target, err := fill(c.url)
+	if err != nil {
+		return nil, err
+	}
+	body, err := fill(c.body)
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(c.Method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	for header, t := range c.headers {
+		value, err := fill(t)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(header, value)
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Target != nil && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	return req, nil
+}
+
+// Makes the call and maps its response
+func (c *HTTPCall) do(req *http.Request) *FutureTaskResponse {
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return &FutureTaskResponse{ResponseCode: -1, Error: err}
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, c.MaxBodySize+1))
+	if err != nil {
+		return &FutureTaskResponse{ResponseCode: res.StatusCode, Error: responseError{err}}
+	}
+	tooLarge := int64(len(body)) > c.MaxBodySize
+	if tooLarge {
+		body = body[:c.MaxBodySize]
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &FutureTaskResponse{ResponseCode: res.StatusCode, Error: &HTTPError{
+			StatusCode: res.StatusCode,
+			Body:       string(body),
+			retryable:  c.Retryable(res.StatusCode),
+		}}
+	}
+	if tooLarge {
+		return &FutureTaskResponse{ResponseCode: res.StatusCode,
+			Error: responseError{fmt.Errorf("the response body is larger than %d bytes", c.MaxBodySize)}}
+	}
+	if c.Target == nil {
+		return &FutureTaskResponse{ResponseCode: res.StatusCode, Data: string(body)}
+	}
+
+	data, err := decodeJSON(body, c.Target)
+	if err != nil {
+		return &FutureTaskResponse{ResponseCode: res.StatusCode,
+			Error: responseError{fmt.Errorf("the response body can not be decoded : %v", err)}}
+	}
+	return &FutureTaskResponse{ResponseCode: res.StatusCode, Data: data}
+}
+
+// Decodes the JSON data into a new value of the type of the target, as the tasks may run concurrently. The value is
+// a pointer, when the target is one. Without a target, it is decoded into an interface{}.
+func decodeJSON(data []byte, target interface{}) (interface{}, error) {
+	if target == nil {
+		var value interface{}
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}
+	targetType := reflect.TypeOf(target)
+	pointer := targetType.Kind() == reflect.Ptr
+	if pointer {
+		targetType = targetType.Elem()
+	}
+	value := reflect.New(targetType)
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return nil, err
+	}
+	if pointer {
+		return value.Interface(), nil
+	}
+	return value.Elem().Interface(), nil
+}
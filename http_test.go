@@ -0,0 +1,182 @@
+package rio
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestHTTPTask(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": "2", "name": ` + string(body) + `}`))
+			return
+		}
+		w.Write([]byte(`{"id": "` + r.URL.Path[len("/users/"):] + `", "name": "` + r.URL.Query().Get("name") + `"}`))
+	}))
+	defer server.Close()
+
+	get, err := NewHTTPTask("GetUser", HTTPCall{
+		URL:     server.URL + "/users/{{index . 0 | path}}?name={{index . 1 | urlquery}}",
+		Headers: map[string]string{"Authorization": "Bearer {{index . 2}}"},
+		Target:  &testUser{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := get.HTTP.call(context.Background(), []interface{}{"1", "rio & co", "secret"})
+	if user, ok := response.Data.(*testUser); response.Error != nil || response.ResponseCode != 200 || !ok ||
+		user.ID != "1" || user.Name != "rio & co" {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	post, _ := NewHTTPTask("CreateUser", HTTPCall{
+		Method:  http.MethodPost,
+		URL:     server.URL + "/users",
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+		Body:    `{{index . 0 | json}}`,
+		Target:  testUser{},
+	})
+	response = post.HTTP.call(context.Background(), []interface{}{`say "hi"`})
+	if user, ok := response.Data.(testUser); response.Error != nil || response.ResponseCode != 201 || !ok ||
+		user.Name != `say "hi"` {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	// Without the authorization, the call fails with the status code
+	unauthorized, _ := NewHTTPTask("GetUser", HTTPCall{URL: server.URL + "/users/1"})
+	response = unauthorized.HTTP.call(context.Background(), nil)
+	if httpErr, ok := response.Error.(*HTTPError); response.ResponseCode != 401 || !ok || httpErr.Retryable() {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	if _, err := NewHTTPTask("Broken", HTTPCall{URL: "{{index . 0"}); err == nil {
+		t.Error("the invalid template is accepted")
+	}
+	failed, _ := NewHTTPTask("Failed", HTTPCall{URL: "http://{{index . 3}}"})
+	if response = failed.HTTP.call(context.Background(), nil); response.Error == nil || response.ResponseCode != -1 {
+		t.Errorf("unexpected response : %+v", response)
+	}
+}
+
+func TestHTTPTaskRetriesRetryableStatuses(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	balancer := GetBalancer(1, 1)
+	for path, expected := range map[string]int32{"/busy": 3, "/missing": 1, "/": 1} {
+		atomic.StoreInt32(&calls, 0)
+		task, _ := NewHTTPTask("Call", HTTPCall{URL: server.URL + path})
+		future, _ := balancer.Submit(BuildRequests(context.Background(), task.WithRetry(2)))
+		responses, err := future.Wait(context.Background())
+		if got := atomic.LoadInt32(&calls); err != nil || got != expected {
+			t.Errorf("%s : unexpected %d calls, %v", path, got, err)
+		}
+		if path == "/" && responses[0].Data != "ok" {
+			t.Errorf("unexpected data %v", responses[0].Data)
+		}
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestHTTPTaskIsAbortedOnTimeoutAndCancel(t *testing.T) {
+	aborted := make(chan bool, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			aborted <- true
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	balancer := GetBalancer(2, 1)
+	task, _ := NewHTTPTask("Slow", HTTPCall{URL: server.URL})
+	future, _ := balancer.Submit(BuildRequests(context.Background(), task.WithMilliSecondTimeout(50)))
+	if _, err := future.Wait(context.Background()); err != ErrTimeout {
+		t.Errorf("unexpected error : %v", err)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Error("the call of the timed out task is not aborted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	task, _ = NewHTTPTask("Slow", HTTPCall{URL: server.URL})
+	future, _ = balancer.Submit(BuildRequests(ctx, task.WithSecondTimeout(10)))
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Error("the call of the cancelled request is not aborted")
+	}
+	<-future.Done()
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestHTTPTaskUnusableResponseIsNotRetried(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte("not json, and longer than the limit"))
+	}))
+	defer server.Close()
+
+	balancer := GetBalancer(1, 1)
+	decoded, _ := NewHTTPTask("Create", HTTPCall{Method: http.MethodPost, URL: server.URL, Target: &testUser{}})
+	limited, _ := NewHTTPTask("Create", HTTPCall{Method: http.MethodPost, URL: server.URL, MaxBodySize: 8})
+	for _, task := range []*FutureTask{decoded, limited} {
+		atomic.StoreInt32(&calls, 0)
+		future, _ := balancer.Submit(BuildRequests(context.Background(), task.WithRetry(2)))
+		responses, err := future.Wait(context.Background())
+		if got := atomic.LoadInt32(&calls); err != nil || got != 1 || responses[0].Error == nil || responses[0].ResponseCode != 200 {
+			t.Errorf("unexpected %d calls, %v", got, err)
+		}
+	}
+
+	// The replicas would make the POST more than once
+	get, _ := NewHTTPTask("Get", HTTPCall{URL: server.URL})
+	post, _ := NewHTTPTask("Create", HTTPCall{Method: http.MethodPost, URL: server.URL})
+	if BuildRequests(context.Background(), get.WithReplica(2)).Validate() != nil ||
+		BuildRequests(context.Background(), post.WithReplica(2)).Validate() == nil {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
@@ -0,0 +1,277 @@
+package rio
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Logger is used by the balancer and its workers to log, a *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Metrics is notified by the balancer on the outcome of the requests and the task attempts. The implementations must
+// be safe for concurrent use, as all the workers report to it.
+type Metrics interface {
+	// Called when a request is rejected by the balancer on posting, like on the admission or the concurrency limit
+	RequestRejected(err error)
+
+	// Called when an attempt of a task ends, the error is of the attempt, like ErrTimeout, or of the response
+	TaskAttempted(task string, latency time.Duration, err error)
+
+	// Called when a request completes, the latency is counted from the posting
+	RequestCompleted(latency time.Duration, err error)
+}
+
+// Tracer is called by the workers when an attempt of a task starts, the returned function is called when it ends,
+// with the response or the error of the attempt. The implementations must be safe for concurrent use.
+type Tracer interface {
+	StartTask(r *Request, task *FutureTask, attempt int) func(response *Response, err error)
+}
+
+// DispatchStrategy picks the worker, a request is dispatched to. The pool is a min heap of the workers by their
+// pending request counts. It is called from the balancer loop only, so it does not need to be safe for concurrent use.
+type DispatchStrategy interface {
+	Pick(pool Pool, r *Request) *Worker
+}
+
+// The least loaded strategy dispatches to the worker with the fewest pending requests. It is the default.
+type LeastLoaded struct{}
+
+func (LeastLoaded) Pick(pool Pool, r *Request) *Worker {
+	return pool[0]
+}
+
+// The round robin strategy dispatches to the workers in turns, regardless of their pending requests
+type RoundRobin struct{}
+
+func (RoundRobin) Pick(pool Pool, r *Request) *Worker {
+	picked := pool[0]
+	for _, w := range pool[1:] {
+		if w.dispatched < picked.dispatched {
+			picked = w
+		}
+	}
+	return picked
+}
+
+// The random strategy dispatches to a random worker
+type Random struct{}
+
+func (Random) Pick(pool Pool, r *Request) *Worker {
+	return pool[rand.Intn(len(pool))]
+}
+
+// RetryPolicy sets the retry count of the tasks, which do not set one. The retries of all the tasks are spaced by the
+// backoff, which is multiplied by the multiplier after every attempt, up to the max backoff.
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	Multiplier float64
+	MaxBackoff time.Duration
+}
+
+// The wait before the retry after the given attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.Backoff)
+	for i := 1; i < attempt && p.Multiplier > 1; i++ {
+		backoff *= p.Multiplier
+		if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(backoff)
+}
+
+// Reports the start of a task attempt to the tracer, the returned function reports its end to the tracer and to the
+// metrics. The error of the end is of the attempt, when there is no response.
+func (b *Balancer) trace(r *Request, task *FutureTask, attempt int) func(*Response, error) {
+	var end func(*Response, error)
+	if b.tracer != nil {
+		end = b.tracer.StartTask(r, task, attempt)
+	}
+	start := time.Now()
+	return func(response *Response, err error) {
+		if response != nil {
+			err = response.Error
+		}
+		b.metrics.TaskAttempted(task.Name, time.Since(start), err)
+		if end != nil {
+			end(response, err)
+		}
+	}
+}
+
+// Reports the completed request to the concurrency limiter and to the metrics, the latency is counted from the posting
+func (b *Balancer) report(r *Request, err error) {
+	b.observe(r, err)
+	b.metrics.RequestCompleted(time.Since(r.postedAt), err)
+}
+
+// Option configures a balancer created by New
+type Option func(*config) error
+
+// The configuration of a balancer, built by the options
+type config struct {
+	workers        int
+	queueSize      int
+	logger         Logger
+	metrics        Metrics
+	tracer         Tracer
+	strategy       DispatchStrategy
+	taskTimeout    time.Duration
+	requestTimeout time.Duration
+	retryPolicy    RetryPolicy
+	admissionLimit int
+	limiter        ConcurrencyLimiter
+}
+
+// The number of workers, default is 10
+func WithWorkers(count int) Option {
+	return func(c *config) error {
+		if count < 1 {
+			return fmt.Errorf("the worker count must be at least 1, got %d", count)
+		}
+		c.workers = count
+		return nil
+	}
+}
+
+// The number of the requests, which can be queued to a worker, default is 1
+func WithQueueSize(size int) Option {
+	return func(c *config) error {
+		if size < 0 {
+			return fmt.Errorf("the queue size can not be negative, got %d", size)
+		}
+		c.queueSize = size
+		return nil
+	}
+}
+
+// The logger of the balancer, default logs to the standard error like the log package
+func WithLogger(logger Logger) Option {
+	return func(c *config) error {
+		if logger == nil {
+			return errors.New("the logger is nil")
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+// The metrics to report to, there is none by default
+func WithMetrics(metrics Metrics) Option {
+	return func(c *config) error {
+		if metrics == nil {
+			return errors.New("the metrics is nil")
+		}
+		c.metrics = metrics
+		return nil
+	}
+}
+
+// The tracer of the task attempts, there is none by default
+func WithTracer(tracer Tracer) Option {
+	return func(c *config) error {
+		if tracer == nil {
+			return errors.New("the tracer is nil")
+		}
+		c.tracer = tracer
+		return nil
+	}
+}
+
+// The strategy to pick the worker for a request, default is LeastLoaded
+func WithDispatchStrategy(strategy DispatchStrategy) Option {
+	return func(c *config) error {
+		if strategy == nil {
+			return errors.New("the dispatch strategy is nil")
+		}
+		c.strategy = strategy
+		return nil
+	}
+}
+
+// The timeouts for the tasks and the requests, which do not set one. A zero timeout means no timeout.
+func WithDefaultTimeouts(taskTimeout, requestTimeout time.Duration) Option {
+	return func(c *config) error {
+		if taskTimeout < 0 || requestTimeout < 0 {
+			return errors.New("the default timeouts can not be negative")
+		}
+		c.taskTimeout = taskTimeout
+		c.requestTimeout = requestTimeout
+		return nil
+	}
+}
+
+// The retry policy for the tasks, which do not set a retry count
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *config) error {
+		if policy.MaxRetries < 0 || policy.Backoff < 0 || policy.MaxBackoff < 0 || policy.Multiplier < 0 {
+			return errors.New("the retry policy can not have negative values")
+		}
+		c.retryPolicy = policy
+		return nil
+	}
+}
+
+// The maximum number of the posted requests, which are not completed yet, see SetAdmissionLimit
+func WithAdmissionLimit(limit int) Option {
+	return func(c *config) error {
+		if limit < 0 {
+			return fmt.Errorf("the admission limit can not be negative, got %d", limit)
+		}
+		c.admissionLimit = limit
+		return nil
+	}
+}
+
+// The adaptive concurrency limiter, see SetConcurrencyLimiter
+func WithConcurrencyLimiter(limiter ConcurrencyLimiter) Option {
+	return func(c *config) error {
+		c.limiter = limiter
+		return nil
+	}
+}
+
+// Use this method to create a balancer with the options. Like GetBalancer, it should be created only once, per the
+// go runtime. It returns an error for an invalid option or an invalid combination of them.
+func New(opts ...Option) (*Balancer, error) {
+	c := defaultConfig()
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.requestTimeout > 0 && c.taskTimeout > c.requestTimeout {
+		return nil, fmt.Errorf("the default task timeout %v is longer than the default request timeout %v",
+			c.taskTimeout, c.requestTimeout)
+	}
+	if c.admissionLimit > 0 && c.admissionLimit < c.workers {
+		return nil, fmt.Errorf("the admission limit %d is lower than the worker count %d, some workers would never "+
+			"get a request", c.admissionLimit, c.workers)
+	}
+	return newBalancer(c), nil
+}
+
+// The configuration, the options are applied on
+func defaultConfig() *config {
+	return &config{
+		workers:   10,
+		queueSize: 1,
+		logger:    log.New(os.Stderr, "", log.LstdFlags),
+		metrics:   noMetrics{},
+		strategy:  LeastLoaded{},
+	}
+}
+
+// The metrics used, when there is none configured
+type noMetrics struct{}
+
+func (noMetrics) RequestRejected(error)                      {}
+func (noMetrics) TaskAttempted(string, time.Duration, error) {}
+func (noMetrics) RequestCompleted(time.Duration, error)      {}
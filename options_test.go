@@ -0,0 +1,155 @@
+package rio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewWithInvalidOptions(t *testing.T) {
+	invalid := [][]Option{
+		{WithWorkers(0)},
+		{WithQueueSize(-1)},
+		{WithLogger(nil)},
+		{WithDispatchStrategy(nil)},
+		{WithDefaultTimeouts(-time.Second, 0)},
+		{WithDefaultTimeouts(2*time.Second, time.Second)},
+		{WithRetryPolicy(RetryPolicy{MaxRetries: -1})},
+		{WithWorkers(4), WithAdmissionLimit(2)},
+	}
+	for _, opts := range invalid {
+		if b, err := New(opts...); err == nil || b != nil {
+			t.Fail()
+		}
+	}
+
+	// The original constructor does not validate its arguments, like before the options
+	balancer := GetBalancerWithTimeouts(0, -1, -time.Second, 0)
+	if _, err := balancer.Stats(); err != nil {
+		t.Error(err)
+	}
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestNewWithOptions(t *testing.T) {
+	var logs bytes.Buffer
+	metrics := &testMetrics{}
+	tracer := &testTracer{}
+	balancer, err := New(
+		WithWorkers(3),
+		WithQueueSize(2),
+		WithLogger(log.New(&logs, "", 0)),
+		WithMetrics(metrics),
+		WithTracer(tracer),
+		WithDispatchStrategy(RoundRobin{}),
+		WithRetryPolicy(RetryPolicy{MaxRetries: 2, Backoff: 10 * time.Millisecond, Multiplier: 2}),
+		WithAdmissionLimit(10),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := func(*BridgeConnection) *FutureTaskResponse {
+		return &FutureTaskResponse{Error: errors.New("failed")}
+	}
+	start := time.Now()
+	future, _ := balancer.Submit(BuildRequests(context.Background(), NewNamedFutureTask("failing", failing)))
+	future.Wait(context.Background())
+
+	// Three attempts, with 10ms and 20ms between them
+	if atomic.LoadInt32(&metrics.attempts) != 3 || atomic.LoadInt32(&tracer.ended) != 3 || time.Since(start) < 30*time.Millisecond {
+		t.Fail()
+	}
+
+	// The task opts out of the retry policy
+	future, _ = balancer.Submit(BuildRequests(context.Background(), NewNamedFutureTask("failing", failing).WithNoRetry()))
+	future.Wait(context.Background())
+	if atomic.LoadInt32(&metrics.attempts) != 4 {
+		t.Fail()
+	}
+
+	for i := 0; i < 5; i++ {
+		future, _ := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(Task4)))
+		future.Wait(context.Background())
+	}
+
+	// The requests are dispatched in turns, even though the workers are idle
+	workers, _ := balancer.Workers()
+	for _, w := range workers {
+		if !strings.Contains(logs.String(), "Dispatching request to ["+w.Name+"]") {
+			t.Fail()
+		}
+	}
+	if atomic.LoadInt32(&metrics.completed) != 7 {
+		t.Fail()
+	}
+
+	stats, _ := balancer.Stats()
+	if stats.AdmissionLimit != 10 {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+type testMetrics struct {
+	attempts  int32
+	completed int32
+	rejected  int32
+}
+
+func (m *testMetrics) RequestRejected(error) {
+	atomic.AddInt32(&m.rejected, 1)
+}
+
+func (m *testMetrics) TaskAttempted(string, time.Duration, error) {
+	atomic.AddInt32(&m.attempts, 1)
+}
+
+func (m *testMetrics) RequestCompleted(time.Duration, error) {
+	atomic.AddInt32(&m.completed, 1)
+}
+
+type testTracer struct {
+	ended int32
+}
+
+func (t *testTracer) StartTask(r *Request, task *FutureTask, attempt int) func(*Response, error) {
+	return func(*Response, error) { atomic.AddInt32(&t.ended, 1) }
+}
+
+func TestCancelledHeldRequestIsReported(t *testing.T) {
+	metrics := &testMetrics{}
+	balancer, err := New(WithWorkers(1), WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balancer.Pause()
+
+	request := BuildRequests(context.Background(), NewFutureTask(Task1))
+	request.ID = "held"
+	future, _ := balancer.Submit(request)
+	if err := balancer.Cancel("held"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := future.Wait(context.Background()); err != context.Canceled {
+		t.Errorf("unexpected error : %v", err)
+	}
+	stats, _ := balancer.Stats()
+	if atomic.LoadInt32(&metrics.completed) != 1 || stats.Completed != 1 || stats.Cancelled != 1 || stats.Held != 0 {
+		t.Errorf("the cancellation is not reported, metrics %d, stats %+v", metrics.completed, stats)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
@@ -0,0 +1,205 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Pipeline is the definition of a chain of tasks, which is built once, like at the startup, and run many times. Each
+// run gets a fresh request from NewRequest, with its own input, so the pipeline is never changed by running it and
+// can be shared by the goroutines.
+//
+// The builder methods return a new pipeline, leaving the original as it is. The tasks are copied when they are added,
+// so changing a task afterwards does not change the pipeline either.
+type Pipeline struct {
+	name           string
+	tasks          []*FutureTask
+	bridges        []Bridge
+	historyBridges []HistoryBridge
+
+	// The definition, the pipeline is built from, it is nil when the pipeline is built in code
+	definition *PipelineDefinition
+}
+
+// Use this method to start a pipeline with its first task, which gets the input of the request as its bridge data
+func NewPipeline(name string, task *FutureTask) *Pipeline {
+	return &Pipeline{name: name, tasks: []*FutureTask{copyTask(task)}}
+}
+
+// The name of the pipeline
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+// Use this method to chain a task in the pipeline, like Request.FollowedBy
+func (p *Pipeline) FollowedBy(bridge Bridge, task *FutureTask) *Pipeline {
+	return p.with(func(r *Request) {
+		r.Bridges = append(r.Bridges, bridge)
+		r.Tasks = append(r.Tasks, copyTask(task))
+	})
+}
+
+// Use this method to chain a task with a history bridge in the pipeline, like Request.FollowedByWithHistory
+func (p *Pipeline) FollowedByWithHistory(bridge HistoryBridge, task *FutureTask) *Pipeline {
+	return p.with(func(r *Request) { r.FollowedByWithHistory(bridge, copyTask(task)) })
+}
+
+// Use this method to add a branch step to the pipeline, like Request.Branch
+func (p *Pipeline) Branch(predicate Predicate, branches ...*Branch) *Pipeline {
+	return p.with(func(r *Request) { r.Branch(predicate, copyBranches(branches)...) })
+}
+
+// Use this method to add a fan out step to the pipeline, like Request.ForEach
+func (p *Pipeline) ForEach(fanOut FanOut, task *FutureTask, parallelism int, reducer Reducer) *Pipeline {
+	return p.with(func(r *Request) { r.ForEach(fanOut, copyTask(task), parallelism, reducer) })
+}
+
+// Use this method to validate the pipeline once it is built, so that the requests made from it do not fail the
+// validation on posting
+func (p *Pipeline) Validate() error {
+	if p == nil {
+		return errors.New("the pipeline is nil")
+	}
+	return p.request().Validate()
+}
+
+// Use this method to create a request to run the pipeline with the input, which is passed to its first task as the
+// bridge data. Post it to the balancer like any other request.
+func (p *Pipeline) NewRequest(ctx context.Context, input ...interface{}) *Request {
+	r := p.request()
+	r.pipeline = p
+	r.Ctx = ctx
+	if len(input) > 0 {
+		r.Input = input
+	}
+	r.CompletedChannel = make(chan bool, 1)
+	return r
+}
+
+// A request with the copies of the task and the bridge lists of the pipeline
+func (p *Pipeline) request() *Request {
+	return &Request{
+		Tasks:          append([]*FutureTask(nil), p.tasks...),
+		Bridges:        append([]Bridge(nil), p.bridges...),
+		HistoryBridges: append([]HistoryBridge(nil), p.historyBridges...),
+	}
+}
+
+// Creates a new pipeline with the request built from this one
+func (p *Pipeline) with(build func(r *Request)) *Pipeline {
+	r := p.request()
+	build(r)
+	return &Pipeline{name: p.name, tasks: r.Tasks, bridges: r.Bridges, historyBridges: r.HistoryBridges}
+}
+
+// A shallow copy of the task, the nested tasks, like the fallback, are copied too
+func copyTask(task *FutureTask) *FutureTask {
+	if task == nil {
+		return nil
+	}
+	copied := *task
+	copied.Fallback = copyTask(task.Fallback)
+	if task.ForEach != nil {
+		forEach := *task.ForEach
+		forEach.Task = copyTask(task.ForEach.Task)
+		copied.ForEach = &forEach
+	}
+	if task.Scatter != nil {
+		scatter := *task.Scatter
+		scatter.Callbacks = append([]Callback(nil), task.Scatter.Callbacks...)
+		copied.Scatter = &scatter
+	}
+	if task.Compensation != nil {
+		compensation := *task.Compensation
+		copied.Compensation = &compensation
+	}
+	if task.Branches != nil {
+		copied.Branches = copyBranches(task.Branches)
+	}
+	return &copied
+}
+
+func copyBranches(branches []*Branch) []*Branch {
+	copied := make([]*Branch, len(branches))
+	for i, branch := range branches {
+		if branch != nil {
+			copied[i] = &Branch{Name: branch.Name, Bridges: append([]Bridge(nil), branch.Bridges...), Tasks: copyTasks(branch.Tasks)}
+		}
+	}
+	return copied
+}
+
+func copyTasks(tasks []*FutureTask) []*FutureTask {
+	copied := make([]*FutureTask, len(tasks))
+	for i, task := range tasks {
+		copied[i] = copyTask(task)
+	}
+	return copied
+}
+
+// Use this method to make a pipeline out of the tasks and the bridges of the request, so that it can be reused or
+// nested in another pipeline, see NewPipelineTask
+func (r *Request) Pipeline(name string) *Pipeline {
+	return &Pipeline{
+		name:           name,
+		tasks:          copyTasks(r.Tasks),
+		bridges:        append([]Bridge(nil), r.Bridges...),
+		historyBridges: append([]HistoryBridge(nil), r.HistoryBridges...),
+	}
+}
+
+// Use this method to create a task, which runs the whole pipeline as a single step of another chain. The bridge data
+// of the task is the input of the pipeline, and its response has the data of the last task of the pipeline, with the
+// responses of all the tasks of the pipeline as its nested responses. It fails, when the pipeline fails, like when a
+// task of it times out or any of its responses has an error.
+//
+// The timeout, the retries, the circuit breaker etc. of the task apply to the pipeline as a unit, the task is named
+// after the pipeline. The tasks of the pipeline have their own timeouts and retries too.
+func NewPipelineTask(pipeline *Pipeline) *FutureTask {
+	if pipeline == nil {
+		return &FutureTask{}
+	}
+	return &FutureTask{Name: pipeline.Name(), Pipeline: pipeline}
+}
+
+// This method runs the pipeline of the task as a request of its own, on a goroutine like the callbacks. The pipeline
+// is stopped, when the attempt times out or the request is done.
+func (w *Worker) doPipeline(ch chan *Response, r *Request, task *FutureTask, bridgeConnection *BridgeConnection, timeout time.Duration, release func()) {
+	go func() {
+		defer release()
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeout != NoTimeout {
+			ctx, cancel = context.WithTimeout(r.Ctx, timeout)
+		} else {
+			ctx, cancel = context.WithCancel(r.Ctx)
+		}
+		defer cancel()
+
+		sub := task.Pipeline.NewRequest(ctx)
+		sub.ID = r.ID + "/" + task.Name
+		sub.Tenant = r.Tenant
+		if bridgeConnection != nil {
+			sub.Input = bridgeConnection.Data
+		}
+
+		preTime := time.Now()
+		err := w.process(sub)
+		response := &Response{ResponseTime: time.Since(preTime), ResponseCode: -1, Error: err, Nested: sub.Responses}
+		if len(sub.Responses) > 0 {
+			last := sub.Responses[len(sub.Responses)-1]
+			response.ResponseCode = last.ResponseCode
+			response.Data = last.Data
+		}
+		if err == nil {
+			for _, nested := range sub.Responses {
+				if nested.Error != nil {
+					response.Error = nested.Error
+					break
+				}
+			}
+		}
+		ch <- response
+	}()
+}
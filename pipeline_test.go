@@ -0,0 +1,119 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipelineIsReused(t *testing.T) {
+	balancer := GetBalancer(4, 2)
+
+	greet := NewNamedFutureTask("Greet", func(bconn *BridgeConnection) *FutureTaskResponse {
+		return &FutureTaskResponse{ResponseCode: 200, Data: fmt.Sprintf("hello %v", bconn.Data[0])}
+	})
+	pipeline := NewPipeline("Greeting", greet).FollowedBy(passBridge, NewFutureTask(echoTask("said ")))
+	if err := pipeline.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the task or extending the pipeline later does not change it
+	greet.WithRetry(5)
+	longer := pipeline.FollowedBy(passBridge, NewFutureTask(echoTask("again ")))
+	if len(pipeline.tasks) != 2 || len(longer.tasks) != 3 || pipeline.tasks[0].RetryCount != 0 {
+		t.Fail()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			future, err := balancer.Submit(pipeline.NewRequest(context.Background(), i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			responses, err := future.Wait(context.Background())
+			if err != nil || responses[1].Data != fmt.Sprintf("said hello %d", i) {
+				t.Errorf("unexpected result : %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestPipelineValidation(t *testing.T) {
+	if err := NewPipeline("Broken", NewFutureTask(Task1)).FollowedBy(nil, NewFutureTask(Task2)).Validate(); err == nil {
+		t.Fail()
+	}
+	if err := NewPipeline("Empty", nil).Validate(); err == nil {
+		t.Fail()
+	}
+	branched := NewPipeline("Branched", NewFutureTask(Task1)).
+		Branch(cacheBranch, NewBranch("hit", passBridge, NewFutureTask(Task2))).
+		ForEach(idsFanOut, NewFutureTask(Task3), 2, nil)
+	if err := branched.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPipelineTask(t *testing.T) {
+	balancer := GetBalancer(2, 1)
+
+	profile := BuildRequests(context.Background(), NewNamedFutureTask("GetUser", echoTask("user "))).
+		FollowedBy(passBridge, NewNamedFutureTask("GetAvatar", echoTask("avatar of "))).
+		Pipeline("Profile")
+	page := NewPipeline("Page", NewPipelineTask(profile)).FollowedBy(passBridge, NewFutureTask(echoTask("page with ")))
+
+	future, _ := balancer.Submit(page.NewRequest(context.Background(), "42"))
+	responses, err := future.Wait(context.Background())
+	if err != nil || responses[1].Data != "page with avatar of user 42" {
+		t.Fatalf("unexpected result : %v", err)
+	}
+	nested := responses[0].Nested
+	if len(nested) != 2 || nested[0].Data != "user 42" || nested[1].Data != "avatar of user 42" {
+		t.Fail()
+	}
+
+	// The timeout and the retries apply to the pipeline as a unit
+	var attempts int32
+	slow := NewPipeline("Slow", NewFutureTask(func(*BridgeConnection) *FutureTaskResponse {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(50 * time.Millisecond)
+		return &FutureTaskResponse{Data: "slow"}
+	}))
+	future, _ = balancer.Submit(BuildRequests(context.Background(), NewPipelineTask(slow).WithMilliSecondTimeout(20)))
+	if _, err := future.Wait(context.Background()); err != ErrTimeout {
+		t.Errorf("unexpected error : %v", err)
+	}
+
+	failing := NewPipeline("Failing", NewFutureTask(valueTask("first", nil))).
+		FollowedBy(passBridge, NewFutureTask(func(*BridgeConnection) *FutureTaskResponse {
+			if atomic.AddInt32(&attempts, 1) < 4 {
+				return &FutureTaskResponse{ResponseCode: 500, Error: errors.New("failed")}
+			}
+			return &FutureTaskResponse{ResponseCode: 200, Data: "second"}
+		}))
+	future, _ = balancer.Submit(BuildRequests(context.Background(), NewPipelineTask(failing).WithRetry(2)))
+	responses, err = future.Wait(context.Background())
+	if err != nil || responses[0].Error != nil || responses[0].Data != "second" || atomic.LoadInt32(&attempts) != 4 {
+		t.Errorf("unexpected result : %v %+v", err, responses[0])
+	}
+
+	if err := BuildRequests(context.Background(), NewPipelineTask(NewPipeline("Broken", nil))).Validate(); err == nil {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
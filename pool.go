@@ -13,12 +13,14 @@ func (p Pool) Less(i, j int) bool {
 
 func (p *Pool) Swap(i, j int) {
 	(*p)[i], (*p)[j] = (*p)[j], (*p)[i]
+	(*p)[i].index = i
+	(*p)[j].index = j
 }
 
 func (p *Pool) Push(x interface{}) {
-	//n := len(*p)
+	n := len(*p)
 	item := x.(*Worker)
-	//item.index = n
+	item.index = n
 	*p = append(*p, item)
 }
 
@@ -26,7 +28,7 @@ func (p *Pool) Pop() interface{} {
 	old := *p
 	n := len(old)
 	item := old[n-1]
-	//item.index = 0 // for safety
+	item.index = -1 // for safety
 	*p = old[0 : n-1]
 	return item
 }
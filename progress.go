@@ -0,0 +1,76 @@
+package rio
+
+// The kind of a progress event
+type ProgressKind int
+
+const (
+	// An attempt of a task is started
+	TaskStarted ProgressKind = iota
+	// An attempt of a task has failed and the task is going to be retried
+	TaskRetried
+	// A task has timed out, the request is stopped
+	TaskTimedOut
+	// A task is completed and its response is final
+	TaskCompleted
+	// The task of a fan out step is completed for an item, see Item
+	ItemCompleted
+)
+
+func (k ProgressKind) String() string {
+	switch k {
+	case TaskStarted:
+		return "started"
+	case TaskRetried:
+		return "retried"
+	case TaskTimedOut:
+		return "timed-out"
+	case TaskCompleted:
+		return "completed"
+	case ItemCompleted:
+		return "item-completed"
+	}
+	return "unknown"
+}
+
+// ProgressEvent is sent on the ProgressChannel of a request, while its tasks are processed. Use it to push the
+// partial results of a chain to the caller, as soon as each task finishes.
+type ProgressEvent struct {
+	Kind ProgressKind
+
+	// The index and the name of the task in the request. The events of the items of a fan out step have the index of
+	// the step and the name of its item task.
+	Index int
+	Name  string
+
+	// The index of the item of a fan out step, for the item completed events
+	Item int
+
+	// The attempt of the task, starting from 1
+	Attempt int
+
+	// The response of the attempt, it is nil for the started and the timed out events
+	Response *Response
+}
+
+// Sends the progress event, if the request has a progress channel. The worker does not wait for the reader, the
+// event is dropped when the channel is full.
+func (r *Request) progress(kind ProgressKind, index int, task *FutureTask, attempt int, response *Response) {
+	if r.ProgressChannel != nil {
+		r.sendProgress(&ProgressEvent{Kind: kind, Index: index, Name: task.Name, Attempt: attempt, Response: response})
+	}
+}
+
+// Sends the item completed event of a fan out step
+func (r *Request) itemProgress(index, item int, task *FutureTask, attempt int, response *Response) {
+	if r.ProgressChannel != nil {
+		r.sendProgress(&ProgressEvent{Kind: ItemCompleted, Index: index, Name: task.Name, Item: item, Attempt: attempt,
+			Response: response})
+	}
+}
+
+func (r *Request) sendProgress(event *ProgressEvent) {
+	select {
+	case r.ProgressChannel <- event:
+	default:
+	}
+}
@@ -0,0 +1,115 @@
+package rio
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestProgressOfChainedTasks(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	request := BuildRequests(context.Background(), NewNamedFutureTask("first", Task1).WithSecondTimeout(10).WithRetry(2)).
+		FollowedBy(Bridge4, NewNamedFutureTask("second", Task5).WithSecondTimeout(10)).
+		WithProgress(make(chan *ProgressEvent, 16))
+	future, _ := balancer.Submit(request)
+
+	events := make([]string, 0)
+	for event := range request.ProgressChannel {
+		events = append(events, fmt.Sprintf("%s:%d:%s:%d", event.Name, event.Index, event.Kind, event.Attempt))
+		if event.Kind == TaskCompleted && event.Response == nil {
+			t.Fail()
+		}
+	}
+	expected := []string{
+		"first:0:started:1", "first:0:retried:1", "first:0:started:2", "first:0:retried:2",
+		"first:0:started:3", "first:0:completed:3", "second:1:started:1", "second:1:completed:1",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected events : %v", events)
+	}
+
+	if responses, err := future.Wait(context.Background()); err != nil || len(responses) != 2 {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestProgressOfTimedOutTask(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	release := make(chan bool)
+	defer close(release)
+	request := BuildRequests(context.Background(), NewFutureTask(blockingTask(release)).WithMilliSecondTimeout(20)).
+		WithProgress(make(chan *ProgressEvent, 10))
+	balancer.PostJob(request)
+	<-request.CompletedChannel
+
+	kinds := make([]ProgressKind, 0)
+	for event := range request.ProgressChannel {
+		kinds = append(kinds, event.Kind)
+	}
+	if len(kinds) != 2 || kinds[0] != TaskStarted || kinds[1] != TaskTimedOut {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestProgressOfFanOutItems(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	request := BuildRequests(context.Background(), NewNamedFutureTask("ids", valueTask([]string{"a", "b"}, nil))).
+		ForEach(idsFanOut, NewNamedFutureTask("lookup", echoTask("user ")), 1, nil).
+		WithProgress(make(chan *ProgressEvent, 16))
+	balancer.PostJob(request)
+	<-request.CompletedChannel
+
+	events := make([]string, 0)
+	for event := range request.ProgressChannel {
+		if event.Kind == ItemCompleted || event.Kind == TaskCompleted {
+			events = append(events, fmt.Sprintf("%s:%d:%s:%d:%v", event.Name, event.Index, event.Kind, event.Item, event.Response.Data))
+		}
+	}
+	expected := []string{"ids:0:completed:0:[a b]", "lookup:1:item-completed:0:user a", "lookup:1:item-completed:1:user b",
+		":1:completed:0:[user a user b]"}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected events : %v", events)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestUnreadProgressChannelDoesNotStallTheWorker(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	request := BuildRequests(context.Background(), NewFutureTask(Task1).WithSecondTimeout(10)).
+		FollowedBy(Bridge4, NewFutureTask(Task5).WithSecondTimeout(10)).
+		WithProgress(make(chan *ProgressEvent, 1))
+	future, _ := balancer.Submit(request)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if responses, err := future.Wait(ctx); err != nil || len(responses) != 2 {
+		t.Fatalf("unexpected result : %v", err)
+	}
+
+	// The first event fits in the buffer, the rest are dropped
+	if event := <-request.ProgressChannel; event.Kind != TaskStarted || event.Index != 0 {
+		t.Fail()
+	}
+	if _, open := <-request.ProgressChannel; open {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
@@ -0,0 +1,213 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// This error is set on the response of a task, when a rate limiter rejects its call
+var ErrRateLimited = errors.New("the rate limit is exceeded")
+
+// The kinds of the rate limiters, as reported in the stats
+const (
+	TaskRateLimiter   = "task"
+	TenantRateLimiter = "tenant"
+)
+
+// The configuration of a rate limiter
+type RateLimit struct {
+	// The number of calls allowed per second
+	Rate float64
+
+	// The number of calls allowed in a burst, it is at least 1
+	Burst int
+
+	// When true, a call waits for its turn, within the task timeout and the request context. Otherwise a call above
+	// the rate is rejected immediately.
+	Wait bool
+}
+
+// The stats of a rate limiter. The tokens are the calls available right now, a negative value means that there are
+// calls waiting for their turn.
+type RateLimiterStats struct {
+	Name     string  `json:"name"`
+	Kind     string  `json:"kind"`
+	Rate     float64 `json:"rate"`
+	Burst    int     `json:"burst"`
+	Tokens   float64 `json:"tokens"`
+	Rejected int     `json:"rejected"`
+}
+
+// RateLimiter is a token bucket, which limits the rate of the calls of the tasks with the same name, or of the
+// requests of the same tenant
+type RateLimiter struct {
+	name  string
+	kind  string
+	limit RateLimit
+
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	rejected int
+}
+
+// Use this method to create a rate limiter, the bucket starts full. Add it to a balancer with AddTaskRateLimiter or
+// AddTenantRateLimiter to use it.
+func NewRateLimiter(name string, limit RateLimit) *RateLimiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &RateLimiter{name: name, limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+// The name of the task or the tenant, the rate limiter is for
+func (l *RateLimiter) Name() string {
+	return l.name
+}
+
+// The current stats of the rate limiter
+func (l *RateLimiter) Stats() *RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	return &RateLimiterStats{
+		Name:     l.name,
+		Kind:     l.kind,
+		Rate:     l.limit.Rate,
+		Burst:    l.limit.Burst,
+		Tokens:   l.tokens,
+		Rejected: l.rejected,
+	}
+}
+
+// Takes a token for a call. If the limiter waits, the call waits for its token, unless it would take longer than
+// maxWait, when maxWait is positive, or the context is done.
+func (l *RateLimiter) take(ctx context.Context, maxWait time.Duration) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	l.refill(time.Now())
+	if l.tokens >= 1 {
+		l.tokens--
+		l.mu.Unlock()
+		return nil
+	}
+	delay := time.Duration((1 - l.tokens) / l.limit.Rate * float64(time.Second))
+	if !l.limit.Wait || l.limit.Rate <= 0 || (maxWait > 0 && delay > maxWait) {
+		l.rejected++
+		l.mu.Unlock()
+		return ErrRateLimited
+	}
+
+	// The token is reserved now, so that the waiting calls get their turns in order
+	l.tokens--
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Gives back the token of a call, which is not made after all, like when another limiter rejects it
+func (l *RateLimiter) refund() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(float64(l.limit.Burst), l.tokens+1)
+}
+
+// Gives back the tokens taken from the limiters
+func refund(limiters []*RateLimiter) {
+	for _, limiter := range limiters {
+		limiter.refund()
+	}
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens = math.Min(float64(l.limit.Burst), l.tokens+elapsed*l.limit.Rate)
+}
+
+// Use this method to add a rate limiter for the tasks with the given name. An earlier limiter for the same name is
+// replaced.
+func (b *Balancer) AddTaskRateLimiter(taskName string, limit RateLimit) *RateLimiter {
+	limiter := NewRateLimiter(taskName, limit)
+	limiter.kind = TaskRateLimiter
+	b.policiesMu.Lock()
+	defer b.policiesMu.Unlock()
+	if b.taskLimiters == nil {
+		b.taskLimiters = make(map[string]*RateLimiter)
+	}
+	b.taskLimiters[taskName] = limiter
+	return limiter
+}
+
+// Use this method to add a rate limiter for the requests of the given tenant, see Request.WithTenant. It limits the
+// calls of all the tasks of these requests together. An earlier limiter for the same tenant is replaced.
+func (b *Balancer) AddTenantRateLimiter(tenant string, limit RateLimit) *RateLimiter {
+	limiter := NewRateLimiter(tenant, limit)
+	limiter.kind = TenantRateLimiter
+	b.policiesMu.Lock()
+	defer b.policiesMu.Unlock()
+	if b.tenantLimiters == nil {
+		b.tenantLimiters = make(map[string]*RateLimiter)
+	}
+	b.tenantLimiters[tenant] = limiter
+	return limiter
+}
+
+// Use this method to get the rate limiter of the tasks with the given name, it is nil when there is none
+func (b *Balancer) TaskRateLimiter(taskName string) *RateLimiter {
+	if taskName == "" {
+		return nil
+	}
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	return b.taskLimiters[taskName]
+}
+
+// Use this method to get the rate limiter of the given tenant, it is nil when there is none
+func (b *Balancer) TenantRateLimiter(tenant string) *RateLimiter {
+	if tenant == "" {
+		return nil
+	}
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	return b.tenantLimiters[tenant]
+}
+
+// The stats of all the rate limiters, sorted by kind and name
+func (b *Balancer) rateLimiterStats() []*RateLimiterStats {
+	b.policiesMu.RLock()
+	defer b.policiesMu.RUnlock()
+	stats := make([]*RateLimiterStats, 0, len(b.taskLimiters)+len(b.tenantLimiters))
+	for _, limiter := range b.taskLimiters {
+		stats = append(stats, limiter.Stats())
+	}
+	for _, limiter := range b.tenantLimiters {
+		stats = append(stats, limiter.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Kind != stats[j].Kind {
+			return stats[i].Kind < stats[j].Kind
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
@@ -0,0 +1,117 @@
+package rio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRejectAndWait(t *testing.T) {
+	limiter := NewRateLimiter("backend", RateLimit{Rate: 1, Burst: 2})
+	if limiter.take(context.Background(), 0) != nil || limiter.take(context.Background(), 0) != nil {
+		t.Fail()
+	}
+	if limiter.take(context.Background(), 0) != ErrRateLimited || limiter.Stats().Rejected != 1 {
+		t.Fail()
+	}
+
+	limiter = NewRateLimiter("backend", RateLimit{Rate: 20, Burst: 1, Wait: true})
+	limiter.take(context.Background(), 0)
+	start := time.Now()
+	if limiter.take(context.Background(), time.Second) != nil || time.Since(start) < 40*time.Millisecond {
+		t.Fail()
+	}
+
+	// The wait longer than the task timeout is rejected right away
+	if limiter.take(context.Background(), time.Millisecond) != ErrRateLimited {
+		t.Fail()
+	}
+
+	// A cancelled wait gives back its token
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if limiter.take(ctx, 0) != context.DeadlineExceeded || limiter.Stats().Tokens < -0.1 {
+		t.Fail()
+	}
+}
+
+func TestRateLimitersInBalancer(t *testing.T) {
+	balancer := GetBalancer(2, 2)
+	balancer.AddTenantRateLimiter("tenant-a", RateLimit{Rate: 0.1, Burst: 1})
+	balancer.AddTaskRateLimiter("lookup", RateLimit{Rate: 0.1, Burst: 2})
+
+	submit := func(tenant string) *Response {
+		request := BuildRequests(context.Background(), NewNamedFutureTask("lookup", Task4).WithSecondTimeout(10)).
+			WithTenant(tenant)
+		future, _ := balancer.Submit(request)
+		responses, _ := future.Wait(context.Background())
+		return responses[0]
+	}
+
+	if submit("tenant-a").Error != nil || submit("tenant-a").Error != ErrRateLimited {
+		t.Fail()
+	}
+	if submit("tenant-b").Error != nil || submit("tenant-b").Error != ErrRateLimited {
+		t.Fail()
+	}
+
+	stats, _ := balancer.Stats()
+	if len(stats.RateLimiters) != 2 || stats.RateLimiters[0].Kind != TaskRateLimiter ||
+		stats.RateLimiters[0].Rejected != 1 || stats.RateLimiters[1].Name != "tenant-a" {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestRejectedCallRefundsTenantToken(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+	tenant := balancer.AddTenantRateLimiter("tenant-a", RateLimit{Rate: 0.001, Burst: 2})
+	balancer.AddTaskRateLimiter("lookup", RateLimit{Rate: 0.001, Burst: 1})
+
+	// The second call is rejected by the task limiter, the token it took from the tenant is given back
+	for i := 0; i < 2; i++ {
+		request := BuildRequests(context.Background(), NewNamedFutureTask("lookup", Task4).WithSecondTimeout(10)).
+			WithTenant("tenant-a")
+		future, _ := balancer.Submit(request)
+		future.Wait(context.Background())
+	}
+	if tokens := tenant.Stats().Tokens; tokens < 0.99 || tokens > 1.01 {
+		t.Errorf("expected one tenant token left, got %v", tokens)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestRejectedCallByBulkheadRefundsTokens(t *testing.T) {
+	balancer := GetBalancer(2, 1)
+	limiter := balancer.AddTaskRateLimiter("lookup", RateLimit{Rate: 0.001, Burst: 2})
+	balancer.AddBulkhead("backend", 1, 0)
+
+	release := make(chan bool)
+	first, _ := balancer.Submit(BuildRequests(context.Background(),
+		NewNamedFutureTask("lookup", blockingTask(release)).WithSecondTimeout(10).WithBulkhead("backend")))
+	for balancer.Bulkhead("backend").Stats().InFlight != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The bulkhead is full, the call is not made, so its token is given back
+	second, _ := balancer.Submit(BuildRequests(context.Background(),
+		NewNamedFutureTask("lookup", Task4).WithSecondTimeout(10).WithBulkhead("backend")))
+	if responses, err := second.Wait(context.Background()); err != nil || responses[0].Error != ErrBulkheadFull {
+		t.Fail()
+	}
+	if tokens := limiter.Stats().Tokens; tokens < 0.99 || tokens > 1.01 {
+		t.Errorf("expected one token left, got %v", tokens)
+	}
+
+	close(release)
+	first.Wait(context.Background())
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
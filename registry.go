@@ -0,0 +1,203 @@
+package rio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// Registry keeps the callbacks, the tasks, the bridges and the pipelines by their name, so that the declarative pipeline
+// definitions can refer to them, see ParsePipelineDefinition. It is safe for concurrent use.
+type Registry struct {
+	mu             sync.RWMutex
+	callbacks      map[string]Callback
+	tasks          map[string]*FutureTask
+	bridges        map[string]Bridge
+	historyBridges map[string]HistoryBridge
+	pipelines      map[string]*Pipeline
+}
+
+// Use this method to create an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		callbacks:      make(map[string]Callback),
+		tasks:          make(map[string]*FutureTask),
+		bridges:        make(map[string]Bridge),
+		historyBridges: make(map[string]HistoryBridge),
+		pipelines:      make(map[string]*Pipeline),
+	}
+}
+
+// Use this method to register a callback by its name, an earlier one with the same name is replaced
+func (r *Registry) RegisterCallback(name string, callback Callback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callbacks[name] = callback
+}
+
+// Use this method to register a task by its name, like an exec or an HTTP task, an earlier one with the same name is
+// replaced. The definitions refer to it like a callback, and every task of them gets a copy of it, with the settings
+// of the definition overriding its own.
+func (r *Registry) RegisterTask(name string, task *FutureTask) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks[name] = task
+}
+
+// Use this method to register a bridge by its name, an earlier one with the same name is replaced
+func (r *Registry) RegisterBridge(name string, bridge Bridge) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bridges[name] = bridge
+}
+
+// Use this method to register a history bridge by its name, an earlier one with the same name is replaced
+func (r *Registry) RegisterHistoryBridge(name string, bridge HistoryBridge) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.historyBridges[name] = bridge
+}
+
+// Use this method to register a pipeline by its name, so that the definitions can nest it as a task. An earlier one
+// with the same name is replaced.
+func (r *Registry) RegisterPipeline(pipeline *Pipeline) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pipelines[pipeline.Name()] = pipeline
+}
+
+// The callback with the name, it is nil when there is none
+func (r *Registry) Callback(name string) Callback {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.callbacks[name]
+}
+
+// The task with the name, it is nil when there is none
+func (r *Registry) Task(name string) *FutureTask {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.tasks[name]
+}
+
+// The bridge with the name, it is nil when there is none
+func (r *Registry) Bridge(name string) Bridge {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bridges[name]
+}
+
+// The history bridge with the name, it is nil when there is none
+func (r *Registry) HistoryBridge(name string) HistoryBridge {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.historyBridges[name]
+}
+
+// The pipeline with the name, it is nil when there is none
+func (r *Registry) Pipeline(name string) *Pipeline {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pipelines[name]
+}
+
+// Use this method to build the pipeline of the definition, with the callbacks, the bridges and the pipelines of the
+// registry. A name, which is not registered, is reported as a *ConfigError with the key of the definition.
+func (r *Registry) Build(definition *PipelineDefinition) (*Pipeline, error) {
+	d := &configDecoder{}
+	if err := definition.validate(d); err != nil {
+		return nil, err
+	}
+	order, edges := definition.chain()
+
+	tasks := make([]*FutureTask, len(order))
+	for i, index := range order {
+		task, err := r.task(d, index, definition.Tasks[index])
+		if err != nil {
+			return nil, err
+		}
+		tasks[i] = task
+	}
+
+	pipeline := NewPipeline(definition.Name, tasks[0])
+	for i, task := range tasks[1:] {
+		edge, key := edges[i], fmt.Sprintf("edges[%d]", edges[i].index)
+		if edge.HistoryBridge != "" {
+			bridge := r.HistoryBridge(edge.HistoryBridge)
+			if bridge == nil {
+				return nil, d.errorf(key+".history_bridge", "there is no history bridge named %q", edge.HistoryBridge)
+			}
+			pipeline = pipeline.FollowedByWithHistory(bridge, task)
+			continue
+		}
+		bridge := r.Bridge(edge.Bridge)
+		if bridge == nil {
+			return nil, d.errorf(key+".bridge", "there is no bridge named %q", edge.Bridge)
+		}
+		pipeline = pipeline.FollowedBy(bridge, task)
+	}
+	pipeline.definition = definition
+
+	if err := pipeline.Validate(); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+// Use this method to parse the JSON or YAML definition and build its pipeline, see Build
+func (r *Registry) ParsePipeline(data []byte) (*Pipeline, error) {
+	definition, err := ParsePipelineDefinition(data)
+	if err != nil {
+		return nil, err
+	}
+	return r.Build(definition)
+}
+
+// Use this method to load the definition from the file and build its pipeline. The file is parsed as JSON, when its
+// content starts with {, and as YAML otherwise.
+func (r *Registry) LoadPipeline(path string) (*Pipeline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := r.ParsePipeline(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %v", path, err)
+	}
+	return pipeline, nil
+}
+
+func (r *Registry) task(d *configDecoder, index int, definition *TaskDefinition) (*FutureTask, error) {
+	key := fmt.Sprintf("tasks[%d]", index)
+	var task *FutureTask
+	if definition.Pipeline != "" {
+		pipeline := r.Pipeline(definition.Pipeline)
+		if pipeline == nil {
+			return nil, d.errorf(key+".pipeline", "there is no pipeline named %q", definition.Pipeline)
+		}
+		task = NewPipelineTask(pipeline)
+		task.Name = definition.Name
+	} else if registered := r.Task(definition.callback()); registered != nil {
+		task = copyTask(registered)
+		task.Name = definition.Name
+	} else {
+		callback := r.Callback(definition.callback())
+		if callback == nil {
+			return nil, d.errorf(key+".callback", "there is no callback or task named %q", definition.callback())
+		}
+		task = NewNamedFutureTask(definition.Name, callback)
+	}
+	if definition.Timeout != 0 {
+		task.Timeout = definition.Timeout
+	}
+	if definition.Retries != 0 {
+		task.RetryCount = definition.Retries
+	}
+	if definition.Replicas != 0 {
+		task.ReplicaCount = definition.Replicas
+	}
+	if definition.Bulkhead != "" {
+		task.Bulkhead = definition.Bulkhead
+	}
+	return task, nil
+}
@@ -0,0 +1,137 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// The timeout of a compensation attempt, when neither the compensation nor its task has a timeout
+var defaultCompensationTimeout = 10 * time.Second
+
+// Compensate undoes the effect of a task, like releasing a reservation. It gets the successful response of the task.
+type Compensate func(*Response) error
+
+// Compensation of a task, with the retry policy of its own
+type Compensation struct {
+	Compensate Compensate
+	Retry      RetryPolicy
+
+	// The timeout of each attempt, the timeout of the task applies when it is zero. An attempt, which times out, fails
+	// with ErrTimeout and is left running, like a timed out task.
+	Timeout time.Duration
+}
+
+// The outcome of a compensation
+type CompensationResult struct {
+	// The index and the name of the compensated task in the request
+	Index int
+	Name  string
+
+	// The number of the attempts made, starting from 1
+	Attempts int
+
+	// The error of the last attempt, it is nil when the compensation has succeeded
+	Error error
+}
+
+// Use this method to add a compensation to a task, which makes a change like a reservation or a payment. When a later
+// task of the request fails, the compensations of the tasks, which have succeeded, are run in the reverse order, saga
+// style. A failed compensation is retried as per the retry policy, and the outcomes are kept in the Compensations of
+// the request. The retries stop, when the context of the caller is done.
+//
+// A request fails, when it is stopped with an error, like a timeout, or when any of its responses has an error. Only
+// the compensations of the tasks in the chain are run, the ones of the tasks inside a branch, a fan out, a fallback or
+// a pipeline task are not.
+func (f *FutureTask) WithCompensation(compensate Compensate, retry RetryPolicy) *FutureTask {
+	f.Compensation = &Compensation{Compensate: compensate, Retry: retry}
+	return f
+}
+
+func (c *Compensation) validate() error {
+	if c.Compensate == nil {
+		return errors.New("the compensate function is nil")
+	}
+	if c.Retry.MaxRetries < 0 || c.Retry.Backoff < 0 || c.Retry.MaxBackoff < 0 || c.Retry.Multiplier < 0 {
+		return errors.New("the retry policy can not have negative values")
+	}
+	if c.Timeout < 0 {
+		return errors.New("the timeout of the compensation is negative")
+	}
+	return nil
+}
+
+// Tells if the request has failed, with the error it is stopped with
+func (r *Request) failed(err error) bool {
+	if err != nil {
+		return true
+	}
+	for _, response := range r.Responses {
+		if response.Error != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// This method runs the compensations of the successful tasks in the reverse order, when the request has failed. They
+// are run even if the request context is done, as they clean up after the request. The responses of the fallbacks
+// and the default values are not compensated, as the change of the task is never made.
+func (w *Worker) compensate(r *Request, err error) {
+	if !r.failed(err) {
+		return
+	}
+	stop := r.callerCtx
+	if stop == nil {
+		stop = context.Background()
+	}
+	for i := len(r.Responses) - 1; i >= 0; i-- {
+		task, response := r.Tasks[i], r.Responses[i]
+		if task.Compensation == nil || response.Error != nil || response.Skipped || response.Fallback {
+			continue
+		}
+		timeout := task.Compensation.Timeout
+		if timeout == 0 {
+			timeout = w.balancer.timeoutOf(task)
+		}
+		if timeout == NoTimeout {
+			timeout = defaultCompensationTimeout
+		}
+		w.balancer.logger.Printf("Compensating the task : %s", task.Name)
+		r.Compensations = append(r.Compensations, task.Compensation.run(stop, i, task.Name, response, timeout))
+	}
+}
+
+// Runs the attempts of the compensation, each one is bounded by the timeout
+func (c *Compensation) run(ctx context.Context, index int, name string, response *Response, timeout time.Duration) *CompensationResult {
+	result := &CompensationResult{Index: index, Name: name}
+	for {
+		result.Attempts++
+		result.Error = c.attempt(response, timeout)
+		if result.Error == nil || result.Attempts > c.Retry.MaxRetries {
+			return result
+		}
+
+		backoff := time.NewTimer(c.Retry.backoff(result.Attempts))
+		select {
+		case <-backoff.C:
+		case <-ctx.Done():
+			backoff.Stop()
+			return result
+		}
+	}
+}
+
+func (c *Compensation) attempt(response *Response, timeout time.Duration) error {
+	// The channel is buffered, so an abandoned attempt does not block forever
+	ch := make(chan error, 1)
+	go func() { ch <- c.Compensate(response) }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-ch:
+		return err
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
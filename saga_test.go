@@ -0,0 +1,139 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompensationsRunInReverseOrder(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	var mu sync.Mutex
+	var undone []string
+	undo := func(failures int) Compensate {
+		return func(response *Response) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if failures > 0 {
+				failures--
+				return errors.New("undo failed")
+			}
+			undone = append(undone, response.Data.(string))
+			return nil
+		}
+	}
+	build := func(shipErr error) *Request {
+		return BuildRequests(context.Background(), NewNamedFutureTask("Reserve", valueTask("reservation", nil)).
+			WithCompensation(undo(0), RetryPolicy{})).
+			FollowedBy(passBridge, NewNamedFutureTask("Charge", valueTask("payment", nil)).
+				WithCompensation(undo(1), RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond})).
+			FollowedBy(passBridge, NewNamedFutureTask("Ship", valueTask(nil, shipErr)))
+	}
+
+	future, _ := balancer.Submit(build(errors.New("no courier")))
+	if _, err := future.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	results := future.Request().Compensations
+	if len(results) != 2 || len(undone) != 2 || undone[0] != "payment" || undone[1] != "reservation" {
+		t.Fatalf("unexpected compensations : %v", undone)
+	}
+	if results[0].Name != "Charge" || results[0].Index != 1 || results[0].Attempts != 2 || results[0].Error != nil ||
+		results[1].Name != "Reserve" || results[1].Attempts != 1 {
+		t.Fail()
+	}
+
+	// Nothing is compensated, when all the tasks succeed
+	undone = nil
+	future, _ = balancer.Submit(build(nil))
+	future.Wait(context.Background())
+	if len(future.Request().Compensations) != 0 || len(undone) != 0 {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestCompensationAfterTimeoutKeepsLastError(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	failing := func(*Response) error { return errors.New("undo failed") }
+	request := BuildRequests(context.Background(), NewNamedFutureTask("Reserve", valueTask("reservation", nil)).
+		WithCompensation(failing, RetryPolicy{MaxRetries: 1})).
+		FollowedBy(passBridge, NewNamedFutureTask("Slow", sleepingTask(50*time.Millisecond, nil)).WithMilliSecondTimeout(10))
+
+	future, _ := balancer.Submit(request)
+	if _, err := future.Wait(context.Background()); err != ErrTimeout {
+		t.Errorf("unexpected error : %v", err)
+	}
+	results := request.Compensations
+	if len(results) != 1 || results[0].Attempts != 2 || results[0].Error == nil {
+		t.Fail()
+	}
+	if err := BuildRequests(context.Background(), NewFutureTask(Task1).WithCompensation(nil, RetryPolicy{})).Validate(); err == nil {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestDefaultedTaskIsNotCompensated(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	var undone []string
+	undo := func(response *Response) error {
+		undone = append(undone, response.Data.(string))
+		return nil
+	}
+	request := BuildRequests(context.Background(), NewNamedFutureTask("Reserve", valueTask("reservation", nil)).
+		WithCompensation(undo, RetryPolicy{})).
+		FollowedBy(passBridge, NewNamedFutureTask("Charge", valueTask(nil, errors.New("declined"))).WithRetry(NoRetries).
+			WithDefault("no payment").WithCompensation(undo, RetryPolicy{})).
+		FollowedBy(passBridge, NewNamedFutureTask("Ship", valueTask(nil, errors.New("no courier"))).WithRetry(NoRetries))
+
+	future, _ := balancer.Submit(request)
+	future.Wait(context.Background())
+	if !request.Responses[1].Fallback || len(request.Compensations) != 1 || len(undone) != 1 || undone[0] != "reservation" {
+		t.Errorf("unexpected compensations : %v", undone)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestHangingCompensationTimesOut(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	release := make(chan bool)
+	defer close(release)
+	hanging := func(*Response) error {
+		<-release
+		return nil
+	}
+	reserve := NewNamedFutureTask("Reserve", valueTask("reservation", nil)).WithCompensation(hanging, RetryPolicy{MaxRetries: 1})
+	reserve.Compensation.Timeout = 20 * time.Millisecond
+	request := BuildRequests(context.Background(), reserve).
+		FollowedBy(passBridge, NewNamedFutureTask("Ship", valueTask(nil, errors.New("no courier"))).WithRetry(NoRetries))
+
+	future, _ := balancer.Submit(request)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := future.Wait(ctx); err != nil {
+		t.Fatalf("the compensation blocks the worker : %v", err)
+	}
+	if results := request.Compensations; len(results) != 1 || results[0].Attempts != 2 || results[0].Error != ErrTimeout {
+		t.Errorf("unexpected compensations : %+v", results)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
@@ -0,0 +1,196 @@
+package rio
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// This error is set on the response of a scatter gather task, when its callbacks do not give enough successful, or
+// agreeing, responses
+var ErrNoQuorum = errors.New("the scatter gather task did not get enough agreeing responses")
+
+// The mode of a scatter gather task, it tells how many of the callbacks must succeed
+type GatherMode int
+
+const (
+	// The first successful response is taken
+	FirstSuccess GatherMode = iota
+	// The task succeeds, once M of the callbacks succeed, see FutureTask.WithQuorum
+	Quorum
+	// All the callbacks must succeed
+	AllSucceed
+)
+
+func (m GatherMode) String() string {
+	switch m {
+	case FirstSuccess:
+		return "first-success"
+	case Quorum:
+		return "quorum"
+	case AllSucceed:
+		return "all"
+	}
+	return "unknown"
+}
+
+// Comparator tells if the two successful responses of a scatter gather task agree, like having the same data
+type Comparator func(a, b *Response) bool
+
+// ScatterGather calls the equivalent callbacks, like the same service in different regions, in parallel and gathers
+// their responses as per its mode
+type ScatterGather struct {
+	Callbacks []Callback
+	Mode      GatherMode
+
+	// The number of the callbacks, which must succeed in the quorum mode. It is the majority, when it is 0. It can not be
+	// set for the other modes.
+	Quorum int
+
+	// When it is set, the successful responses must also agree with each other to be counted together. Otherwise any
+	// successful responses are counted.
+	Comparator Comparator
+}
+
+// Use this method to create a task, which calls all the callbacks in parallel with the same bridge data. As per the
+// mode, the task succeeds with the first successful response, with M of them, see WithQuorum, or when all of them
+// succeed. Its response has the data of the first of the agreeing responses, and the responses of all the callbacks
+// are kept in its nested responses. The callbacks, which do not respond before the outcome is decided, are marked as
+// Skipped there.
+//
+// The task is run like any other task, its timeout, retries, circuit breaker etc. apply to all the callbacks as a
+// unit.
+func NewScatterGatherTask(name string, mode GatherMode, callbacks ...Callback) *FutureTask {
+	return &FutureTask{Name: name, Scatter: &ScatterGather{Callbacks: callbacks, Mode: mode}}
+}
+
+// Use this method to make a scatter gather task succeed, once m of its callbacks succeed
+func (f *FutureTask) WithQuorum(m int) *FutureTask {
+	if f.Scatter == nil {
+		log.Println("Error : The quorum is only for the scatter gather tasks")
+		return f
+	}
+	f.Scatter.Mode = Quorum
+	f.Scatter.Quorum = m
+	return f
+}
+
+// Use this method to count only the agreeing responses of a scatter gather task together, see Comparator
+func (f *FutureTask) WithComparator(comparator Comparator) *FutureTask {
+	if f.Scatter == nil {
+		log.Println("Error : The comparator is only for the scatter gather tasks")
+		return f
+	}
+	f.Scatter.Comparator = comparator
+	return f
+}
+
+func (s *ScatterGather) validate() error {
+	if len(s.Callbacks) == 0 {
+		return errors.New("there are no callbacks")
+	}
+	for i, callback := range s.Callbacks {
+		if callback == nil {
+			return fmt.Errorf("the callback %d is nil", i)
+		}
+	}
+	if s.Mode < FirstSuccess || s.Mode > AllSucceed {
+		return fmt.Errorf("unknown mode %d", s.Mode)
+	}
+	if s.Quorum < 0 || s.Quorum > len(s.Callbacks) {
+		return fmt.Errorf("the quorum %d is negative or more than the callback count %d", s.Quorum, len(s.Callbacks))
+	}
+	if s.Quorum > 0 && s.Mode != Quorum {
+		return fmt.Errorf("the quorum %d is set for the %s mode", s.Quorum, s.Mode)
+	}
+	return nil
+}
+
+// The number of the agreeing successful responses needed
+func (s *ScatterGather) required() int {
+	switch s.Mode {
+	case Quorum:
+		if s.Quorum > 0 {
+			return s.Quorum
+		}
+		return len(s.Callbacks)/2 + 1
+	case AllSucceed:
+		return len(s.Callbacks)
+	}
+	return 1
+}
+
+// Calls all the callbacks and gathers their responses. It returns as soon as the outcome is decided, the callbacks
+// still running then, write to the buffered channel and are left behind.
+func (s *ScatterGather) gather(bridgeConnection *BridgeConnection) *Response {
+	type result struct {
+		index    int
+		response *Response
+	}
+
+	start := time.Now()
+	results := make(chan result, len(s.Callbacks))
+	for i, callback := range s.Callbacks {
+		go func(i int, callback Callback) {
+			preTime := time.Now()
+			futureTaskResponse := callback(bridgeConnection)
+			results <- result{i, &Response{
+				ResponseTime: time.Since(preTime),
+				ResponseCode: futureTaskResponse.ResponseCode,
+				Data:         futureTaskResponse.Data,
+				Error:        futureTaskResponse.Error,
+			}}
+		}(i, callback)
+	}
+
+	required := s.required()
+	nested := make([]*Response, len(s.Callbacks))
+
+	// The successful responses, grouped by their agreement
+	var groups [][]*Response
+	var agreed *Response
+	for received := 1; received <= len(s.Callbacks) && agreed == nil; received++ {
+		result := <-results
+		nested[result.index] = result.response
+
+		largest := 0
+		if result.response.Error == nil {
+			groups = s.join(groups, result.response)
+		}
+		for _, group := range groups {
+			if len(group) >= required {
+				agreed = group[0]
+			}
+			if len(group) > largest {
+				largest = len(group)
+			}
+		}
+
+		// There is no point in waiting, when the rest of the callbacks can not make a group large enough
+		if agreed == nil && largest+len(s.Callbacks)-received < required {
+			break
+		}
+	}
+
+	for i := range nested {
+		if nested[i] == nil {
+			nested[i] = &Response{ResponseCode: -1, Skipped: true}
+		}
+	}
+	if agreed == nil {
+		return &Response{ResponseTime: time.Since(start), ResponseCode: -1, Error: ErrNoQuorum, Nested: nested}
+	}
+	return &Response{ResponseTime: time.Since(start), ResponseCode: agreed.ResponseCode, Data: agreed.Data, Nested: nested}
+}
+
+// Adds the successful response to the group it agrees with, or to a new group
+func (s *ScatterGather) join(groups [][]*Response, response *Response) [][]*Response {
+	for i, group := range groups {
+		if s.Comparator == nil || s.Comparator(group[0], response) {
+			groups[i] = append(group, response)
+			return groups
+		}
+	}
+	return append(groups, []*Response{response})
+}
@@ -0,0 +1,110 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func regionTask(d time.Duration, data interface{}, err error) Callback {
+	return func(*BridgeConnection) *FutureTaskResponse {
+		time.Sleep(d)
+		return &FutureTaskResponse{ResponseCode: 200, Data: data, Error: err}
+	}
+}
+
+func TestScatterGatherModes(t *testing.T) {
+	balancer := GetBalancer(2, 1)
+	failed := errors.New("region down")
+	sameData := func(a, b *Response) bool { return a.Data == b.Data }
+
+	run := func(task *FutureTask) (*Response, time.Duration) {
+		start := time.Now()
+		future, err := balancer.Submit(BuildRequests(context.Background(), task))
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses, err := future.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return responses[0], time.Since(start)
+	}
+
+	// The first success does not wait for the slow region, the failed one is skipped over
+	response, elapsed := run(NewScatterGatherTask("Read", FirstSuccess,
+		regionTask(0, nil, failed), regionTask(10*time.Millisecond, "eu", nil), regionTask(200*time.Millisecond, "us", nil)))
+	nested := response.Nested
+	if response.Error != nil || response.Data != "eu" || elapsed > 150*time.Millisecond {
+		t.Errorf("unexpected response : %+v", response)
+	}
+	if len(nested) != 3 || nested[0].Error != failed || nested[1].Data != "eu" || !nested[2].Skipped {
+		t.Fail()
+	}
+
+	// Two of the three must agree, the odd one out is not counted
+	response, _ = run(NewScatterGatherTask("Read", Quorum,
+		regionTask(0, "v1", nil), regionTask(10*time.Millisecond, "v2", nil), regionTask(20*time.Millisecond, "v2", nil)).
+		WithQuorum(2).WithComparator(sameData))
+	if response.Error != nil || response.Data != "v2" {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	// The majority is the default quorum, there is no agreement here
+	response, _ = run(NewScatterGatherTask("Read", Quorum,
+		regionTask(0, "v1", nil), regionTask(0, "v2", nil), regionTask(0, "v3", nil)).WithComparator(sameData))
+	if response.Error != ErrNoQuorum || len(response.Nested) != 3 {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	// All must succeed, a failure decides the outcome without waiting for the slow one
+	response, elapsed = run(NewScatterGatherTask("Write", AllSucceed,
+		regionTask(0, "ok", nil), regionTask(0, nil, failed), regionTask(200*time.Millisecond, "ok", nil)))
+	if response.Error != ErrNoQuorum || elapsed > 150*time.Millisecond || !response.Nested[2].Skipped {
+		t.Errorf("unexpected response : %+v", response)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestScatterGatherValidation(t *testing.T) {
+	invalid := []*FutureTask{
+		NewScatterGatherTask("Read", FirstSuccess),
+		NewScatterGatherTask("Read", FirstSuccess, nil),
+		NewScatterGatherTask("Read", Quorum, regionTask(0, "v", nil)).WithQuorum(2),
+		NewScatterGatherTask("Read", GatherMode(7), regionTask(0, "v", nil)),
+		{Name: "Read", Scatter: &ScatterGather{Callbacks: []Callback{regionTask(0, "v", nil)}, Mode: FirstSuccess, Quorum: 1}},
+		{Name: "Read", Scatter: &ScatterGather{Callbacks: []Callback{regionTask(0, "v", nil)}, Mode: AllSucceed, Quorum: 1}},
+	}
+	for _, task := range invalid {
+		if err := BuildRequests(context.Background(), task).Validate(); err == nil {
+			t.Errorf("expected an error for %+v", task.Scatter)
+		}
+	}
+}
+
+func TestReplicasAreCalledAsManyTimes(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	var calls int32
+	task := NewFutureTask(func(*BridgeConnection) *FutureTaskResponse {
+		atomic.AddInt32(&calls, 1)
+		return &FutureTaskResponse{ResponseCode: 200, Data: "Replica"}
+	}).WithReplica(3)
+	future, _ := balancer.Submit(BuildRequests(context.Background(), task))
+	if responses, err := future.Wait(context.Background()); err != nil || responses[0].Data != "Replica" {
+		t.Fail()
+	}
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("unexpected call count : %d", calls)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
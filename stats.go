@@ -0,0 +1,126 @@
+package rio
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is the snapshot of the balancer state, it is used to monitor the balancer at runtime
+type Stats struct {
+	Workers          int        `json:"workers"`
+	RetiringWorkers  int        `json:"retiring_workers"`
+	Pending          int        `json:"pending"`
+	Held             int        `json:"held"`
+	Admitted         int        `json:"admitted"`
+	AdmissionLimit   int        `json:"admission_limit"`
+	ConcurrencyLimit int        `json:"concurrency_limit,omitempty"`
+	Paused           bool       `json:"paused"`
+	PausedUntil      *time.Time `json:"paused_until,omitempty"`
+	Completed        int        `json:"completed"`
+	Cancelled        int        `json:"cancelled"`
+
+	CircuitBreakers []*BreakerStats     `json:"circuit_breakers"`
+	Bulkheads       []*BulkheadStats    `json:"bulkheads"`
+	RateLimiters    []*RateLimiterStats `json:"rate_limiters"`
+}
+
+// WorkerInfo is the snapshot of a worker, with the ids of the requests queued to it
+type WorkerInfo struct {
+	Name     string   `json:"name"`
+	Pending  int      `json:"pending"`
+	Retiring bool     `json:"retiring"`
+	Requests []string `json:"requests"`
+}
+
+// The states of a request, as reported in RequestInfo
+const (
+	RequestHeld    = "held"
+	RequestQueued  = "queued"
+	RequestRunning = "running"
+)
+
+// RequestInfo is the snapshot of a request, which is posted to the balancer and is not yet completed
+type RequestInfo struct {
+	ID     string        `json:"id"`
+	State  string        `json:"state"`
+	Worker string        `json:"worker,omitempty"`
+	Tasks  int           `json:"tasks"`
+	Posted time.Time     `json:"posted"`
+	Age    time.Duration `json:"age"`
+}
+
+// Use this method to get the current stats of the balancer
+func (b *Balancer) Stats() (*Stats, error) {
+	var stats *Stats
+	err := b.do(func() {
+		stats = &Stats{
+			Workers:         len(b.pool),
+			RetiringWorkers: len(b.retiring),
+			Pending:         b.queuedItems,
+			Held:            len(b.held),
+			Admitted:        int(atomic.LoadInt64(&b.admitted)),
+			AdmissionLimit:  int(atomic.LoadInt64(&b.admissionLimit)),
+			Paused:          b.paused,
+			Completed:       b.completedCount,
+			Cancelled:       b.cancelledCount,
+			CircuitBreakers: b.breakerStats(),
+			Bulkheads:       b.bulkheadStats(),
+			RateLimiters:    b.rateLimiterStats(),
+		}
+		if limiter := b.concurrencyLimiter(); limiter != nil {
+			stats.ConcurrencyLimit = limiter.Limit()
+		}
+		if !b.pausedUntil.IsZero() {
+			until := b.pausedUntil
+			stats.PausedUntil = &until
+		}
+	})
+	return stats, err
+}
+
+// Use this method to get the list of the workers with their pending request counts
+func (b *Balancer) Workers() ([]*WorkerInfo, error) {
+	var infos []*WorkerInfo
+	err := b.do(func() {
+		for _, w := range b.workers() {
+			info := &WorkerInfo{Name: w.Name, Pending: w.pending, Retiring: w.retiring, Requests: make([]string, 0, len(w.inFlight))}
+			for _, req := range w.inFlight {
+				info.Requests = append(info.Requests, req.ID)
+			}
+			infos = append(infos, info)
+		}
+	})
+	return infos, err
+}
+
+// Use this method to get the list of the requests, which are posted to the balancer and are not yet completed
+func (b *Balancer) InFlight() ([]*RequestInfo, error) {
+	var infos []*RequestInfo
+	err := b.do(func() {
+		now := time.Now()
+		for _, w := range b.workers() {
+			for i, req := range w.inFlight {
+				state := RequestQueued
+				if i == 0 {
+					state = RequestRunning
+				}
+				infos = append(infos, newRequestInfo(req, state, w.Name, now))
+			}
+		}
+		for _, req := range b.held {
+			infos = append(infos, newRequestInfo(req, RequestHeld, "", now))
+		}
+	})
+	return infos, err
+}
+
+func newRequestInfo(req *Request, state, worker string, now time.Time) *RequestInfo {
+	return &RequestInfo{
+		ID:     req.ID,
+		State:  state,
+		Worker: worker,
+		Tasks:  req.taskCount,
+		Posted: req.postedAt,
+		Age:    now.Sub(req.postedAt),
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"time"
 )
 
@@ -15,6 +16,13 @@ type FutureTaskResponse struct {
 	Error        error
 }
 
+// The errors of the task responses can implement it, to tell if the task should be retried, like HTTPError does. The
+// other errors are always retried, as long as the task has retries left.
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
 // During callback chaining, ue this to setup the callbacks, see the example
 var EMPTY_ARG_PLACEHOLDER = ""
 
@@ -25,6 +33,14 @@ var EMPTY_CALLBACK_RESPONSE = &FutureTaskResponse{
 	Error:        errors.New("The callback didn't run due to argument unavailability"),
 }
 
+// Use this as the timeout of a task, which must never time out. A task with a zero timeout gets the default timeout
+// of the balancer, and when there is no default, it never times out either.
+const NoTimeout time.Duration = math.MaxInt64
+
+// Use this as the retry count of a task, which must never be retried. A task with a zero retry count gets the retries
+// of the retry policy of the balancer.
+const NoRetries = math.MinInt32
+
 // This is task which will be executed in future
 type FutureTask struct {
 	Name         string
@@ -32,6 +48,40 @@ type FutureTask struct {
 	Timeout      time.Duration
 	RetryCount   int
 	ReplicaCount int
+
+	// When the task fails, after all the retries, or times out, the fallback task is run in its place. If that fails
+	// too or there is no fallback task, the default value is used as the response data, when it is not nil.
+	Fallback *FutureTask
+	Default  interface{}
+
+	// The name of the bulkhead, which limits the concurrent calls of the task, along with the other tasks calling the
+	// same backend
+	Bulkhead string
+
+	// When the predicate is set, the task is a branch step. It has no callback, instead the predicate chooses one of
+	// the branches to run, see Request.Branch.
+	Predicate Predicate
+	Branches  []*Branch
+
+	// When it is set, the task is a fan out step, which runs a task for each item of the previous response, see
+	// Request.ForEach
+	ForEach *ForEach
+
+	// When it is set, the task calls all the callbacks of the scatter gather instead of its callback, see
+	// NewScatterGatherTask
+	Scatter *ScatterGather
+
+	// The compensation, which undoes the task, when a later task of the request fails, see WithCompensation
+	Compensation *Compensation
+
+	// When it is set, the task runs the pipeline instead of its callback, see NewPipelineTask
+	Pipeline *Pipeline
+
+	// When it is set, the task runs the command as a local process instead of its callback, see NewExecTask
+	Exec *Command
+
+	// When it is set, the task makes the HTTP call instead of its callback, see NewHTTPTask
+	HTTP *HTTPCall
 }
 
 // Its how two callbacks communicate with each other, this is a function which knows how to convert
@@ -49,11 +99,52 @@ type BridgeConnection struct {
 
 // Request is the one that is sent to the *balancer* to be used to call concurrently
 type Request struct {
-	Tasks            []*FutureTask
-	Bridges          []Bridge
-	Responses        []*Response
+	// The id of the request. If it is empty, the balancer assigns one when the request is posted.
+	ID string
+
+	// The tenant, the request is made for. The calls of the request are limited by the rate limiter of the tenant.
+	Tenant string
+
+	// The total time the request may take, counted from the posting. It is applied as a deadline on the request
+	// context, which is split by the worker among the tasks, see Worker.execute.
+	Timeout time.Duration
+
+	// The input of the request, the first task gets it as its bridge data
+	Input []interface{}
+
+	Tasks     []*FutureTask
+	Bridges   []Bridge
+	Responses []*Response
+
+	// The channel is closed, when the request is completed, so it can be read any time after, any number of times. A
+	// reader waiting at the time, or the buffer of the channel, gets true before that. Do not share the channel
+	// between the requests.
 	CompletedChannel chan bool
 	Ctx              context.Context
+
+	// The history bridges, matched with the tasks like the bridges. A task with a history bridge has a nil bridge,
+	// see FollowedByWithHistory.
+	HistoryBridges []HistoryBridge
+
+	// The optional channel to stream the progress of the request. The worker sends an event for every attempt, retry
+	// and completion of the tasks, and closes the channel when the request completes. Use a buffered channel, big
+	// enough for the events the reader may lag behind, as the worker does not wait and drops the events, which do not
+	// fit in it.
+	ProgressChannel chan *ProgressEvent
+
+	// The outcomes of the compensations run, when the request has failed, in the order they are run
+	Compensations []*CompensationResult
+
+	// The pipeline, the request is created from, it is nil when the request is built in code
+	pipeline *Pipeline
+
+	// These are set by the balancer, when the request is posted, the caller context is the one before the balancer
+	// wrapped it with the timeout
+	callerCtx  context.Context
+	future     *Future
+	cancelFunc context.CancelFunc
+	postedAt   time.Time
+	taskCount  int
 }
 
 // Response is the one that is sent to the graphql layer to be sent to the caller
@@ -62,6 +153,15 @@ type Response struct {
 	ResponseCode int
 	Data         interface{}
 	Error        error
+
+	// Its true, when the response comes from the fallback task or the default value of the task
+	Fallback bool
+
+	// Its true, when the task is not run as its branch is not chosen
+	Skipped bool
+
+	// The responses of the tasks inside the step, like the tasks of all the branches of a branch step
+	Nested []*Response
 }
 
 // GetResponse method gives the response from the request, based on index, use this method, when there are multiple
@@ -110,6 +210,12 @@ func (f *FutureTask) WithSecondTimeout(t int) *FutureTask {
 	return f
 }
 
+// Make the task wait for its callback as long as it takes, regardless of the default timeout of the balancer
+func (f *FutureTask) WithNoTimeout() *FutureTask {
+	f.Timeout = NoTimeout
+	return f
+}
+
 // Add retry count to the task. If the task fails, it will be retried this many times. The failure information, comes
 // from the task itself.
 func (f *FutureTask) WithRetry(c int) *FutureTask {
@@ -117,6 +223,12 @@ func (f *FutureTask) WithRetry(c int) *FutureTask {
 	return f
 }
 
+// Make the task fail on its first failed attempt, regardless of the retry policy of the balancer
+func (f *FutureTask) WithNoRetry() *FutureTask {
+	f.RetryCount = NoRetries
+	return f
+}
+
 // Add replica calls. Use this when there is a possibility to get different response time from a service for successive
 // calls and only the fastest one is needed. The worker will use call the service concurrently, this many times and only
 // the fastest will be picked.
@@ -125,23 +237,54 @@ func (f *FutureTask) WithReplica(c int) *FutureTask {
 	return f
 }
 
+// Add a fallback task, which is run with the same bridge data, when this task fails or times out. Use it to call a
+// cached or a secondary source, so that the chain can continue.
+func (f *FutureTask) WithFallback(task *FutureTask) *FutureTask {
+	f.Fallback = task
+	return f
+}
+
+// Add a default value, which is used as the response data, when this task and its fallback task fail or time out
+func (f *FutureTask) WithDefault(value interface{}) *FutureTask {
+	f.Default = value
+	return f
+}
+
+// Tag the task with a bulkhead, added to the balancer with AddBulkhead. The task then waits for a free slot of the
+// bulkhead, before calling the backend.
+func (f *FutureTask) WithBulkhead(name string) *FutureTask {
+	f.Bulkhead = name
+	return f
+}
+
 // Use this method to build a request instance, which is sent on the balancer to be processed. Use this variant when
 // there is a job chaining required and multiple tasks are involved, one after another.
 func BuildRequests(context context.Context, task *FutureTask) *Request {
 	tasks := make([]*FutureTask, 0, 1)
 	tasks = append(tasks, task)
-	return &Request{Ctx: context, Tasks: tasks, CompletedChannel: make(chan bool)}
+	return &Request{Ctx: context, Tasks: tasks, CompletedChannel: make(chan bool, 1)}
 }
 
 // This method validates the posted job/request to the balancer. If validation fails, balancer sends the error to the
 // calling goroutine immediately, otherwise sends the request to the workers.
 func (r Request) Validate() error {
-	if r.CompletedChannel == nil {
-		return errors.New("The request CompletedChannel is nil")
-	}
 	if r.Tasks == nil || len(r.Tasks) == 0 {
 		return errors.New("please provide some tasks to process, the task list is empty")
 	}
+	if r.Timeout < 0 {
+		return errors.New("the request timeout can not be negative")
+	}
+	for i, task := range r.Tasks {
+		if err := task.validate(); err != nil {
+			return fmt.Errorf("task %d : %v", i, err)
+		}
+		if i == 0 && task.ownsBridge() {
+			return errors.New("task 0 : a branch or a fan out step needs a task before it, to get its response")
+		}
+		if i > 0 && i <= len(r.Bridges) && r.Bridges[i-1] == nil && r.historyBridge(i-1) == nil && !task.ownsBridge() {
+			return fmt.Errorf("task %d : the bridge to the task is nil", i)
+		}
+	}
 	if length := len(r.Tasks); length > 1 && length != len(r.Bridges)+1 {
 		return errors.New("for a followed by construct, there should be n requests and (n-1) bridges")
 	}
@@ -153,6 +296,115 @@ func (r Request) Validate() error {
 	return nil
 }
 
+// Tells if the task is a step, which bridges the previous response itself, like a branch or a fan out step. The
+// bridge to such a step is nil.
+func (f *FutureTask) ownsBridge() bool {
+	return f.Predicate != nil || f.ForEach != nil
+}
+
+// Validates the settings of the task and of its fallback tasks
+func (f *FutureTask) validate() error {
+	if f == nil {
+		return errors.New("the task is nil")
+	}
+	if f.Predicate != nil {
+		return f.validateBranches()
+	}
+	if f.ForEach != nil {
+		return f.ForEach.validate()
+	}
+	if f.Scatter != nil {
+		if err := f.Scatter.validate(); err != nil {
+			return fmt.Errorf("the scatter gather task %q : %v", f.Name, err)
+		}
+	} else if f.Pipeline != nil {
+		if err := f.Pipeline.Validate(); err != nil {
+			return fmt.Errorf("the pipeline task %q : %v", f.Name, err)
+		}
+	} else if f.Exec != nil {
+		if f.Exec.stdin == nil {
+			return fmt.Errorf("the command of the task %q is not created by NewExecTask", f.Name)
+		}
+		if f.ReplicaCount > 0 {
+			return fmt.Errorf("the exec task %q can not have replicas, the command would run more than once", f.Name)
+		}
+	} else if f.HTTP != nil {
+		if f.HTTP.url == nil {
+			return fmt.Errorf("the http call of the task %q is not created by NewHTTPTask", f.Name)
+		}
+		if f.ReplicaCount > 1 && !idempotent(f.HTTP.Method) {
+			return fmt.Errorf("the http task %q can not have replicas, the %s call would be made more than once", f.Name,
+				f.HTTP.Method)
+		}
+	} else if f.Callback == nil {
+		return fmt.Errorf("the callback of the task %q is nil", f.Name)
+	}
+	if f.Timeout < 0 {
+		return fmt.Errorf("the timeout of the task %q is negative, use NoTimeout for a task which never times out", f.Name)
+	}
+	if f.RetryCount < 0 && f.RetryCount != NoRetries {
+		return fmt.Errorf("the retry count of the task %q is negative, use NoRetries for a task which is never retried", f.Name)
+	}
+	if f.ReplicaCount < 0 {
+		return fmt.Errorf("the replica count of the task %q is negative", f.Name)
+	}
+	if f.Compensation != nil {
+		if err := f.Compensation.validate(); err != nil {
+			return fmt.Errorf("compensation of the task %q : %v", f.Name, err)
+		}
+	}
+	if f.Fallback != nil {
+		if f.Fallback.ownsBridge() {
+			return fmt.Errorf("the fallback of the task %q can not be a branch or a fan out step", f.Name)
+		}
+		if err := f.Fallback.validate(); err != nil {
+			return fmt.Errorf("fallback of the task %q : %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// Completes the future of the request and notifies the CompletedChannel, if there is one. The channel is closed
+// after the notification, which does not block, so a caller which does not read the channel can not stall the worker
+// and a late reader still gets it.
+func (r *Request) complete(err error) {
+	if r.ProgressChannel != nil {
+		close(r.ProgressChannel)
+	}
+	r.future.complete(err)
+	if r.CompletedChannel != nil {
+		select {
+		case r.CompletedChannel <- true:
+		default:
+		}
+		close(r.CompletedChannel)
+	}
+}
+
+// Use this method to set the input of the request, see Input
+func (r *Request) WithInput(input ...interface{}) *Request {
+	r.Input = input
+	return r
+}
+
+// Use this method to set the tenant of the request, see Tenant
+func (r *Request) WithTenant(tenant string) *Request {
+	r.Tenant = tenant
+	return r
+}
+
+// Use this method to set the total time the request may take, see Timeout
+func (r *Request) WithTimeout(d time.Duration) *Request {
+	r.Timeout = d
+	return r
+}
+
+// Use this method to set a channel to stream the progress of the request, see ProgressChannel
+func (r *Request) WithProgress(ch chan *ProgressEvent) *Request {
+	r.ProgressChannel = ch
+	return r
+}
+
 // This construct is used to create task chaining. If task2 depends on task1 in terms of data and the execution is to
 // happen like task1-->task2, then use this method to chain them together by means of a Bridge type
 func (r *Request) FollowedBy(bridge Bridge, task *FutureTask) *Request {
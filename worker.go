@@ -1,10 +1,16 @@
 package rio
 
 import (
-	"log"
+	"errors"
 	"time"
 )
 
+// This is the error of a request, which is stopped as one of its tasks has timed out
+var ErrTimeout = errors.New("the task has timed out")
+
+// This is the error of a request, which is stopped as the rest of its deadline can not cover the next call
+var ErrBudgetExhausted = errors.New("the request deadline can not cover the next call")
+
 // The worker struct, it has all the attributes that is needed by a worker to do its thing
 type Worker struct {
 
@@ -20,11 +26,30 @@ type Worker struct {
 	// The index value is used by the priority queue to move it back and forth in the heap
 	index int
 
+	// The requests sent to the worker and not yet completed, in the order of dispatch. Like pending, it is only
+	// touched by the balancer.
+	inFlight []*Request
+
+	// Its set by the balancer, when the worker is taken out of the pool by a resize
+	retiring bool
+
+	// The number of requests dispatched to the worker so far, used by the round robin strategy
+	dispatched int
+
 	// Its the copy of the balancer done channel, passed to all the worker
 	done chan *Worker
 
 	// Its the close channel to close a worker. Its used by the balancer only, hence unexported
 	closeChannel chan chan bool
+
+	// The balancer, which created the worker. The worker uses it to reach the shared policies, like the circuit
+	// breakers.
+	balancer *Balancer
+}
+
+// The number of the requests, which are queued to the worker and are not completed yet
+func (w *Worker) Pending() int {
+	return w.pending
 }
 
 // The balancer calls the method to queue a new request to the worker
@@ -46,123 +71,316 @@ func (w *Worker) Run() {
 			case callback := <-w.closeChannel:
 				close(w.closeChannel)
 				close(w.requests)
-				log.Println("Closing worker : ", w.Name)
+				w.balancer.logger.Printf("Closing worker : %s", w.Name)
 				callback <- true
 				return
 
 			case r := <-w.requests:
 
-				// Create a slice of response with equal size of the number of requests
-				r.Responses = make([]*Response, 0, len(r.Tasks))
+				// The request might be cancelled while it was waiting in the queue
+				if r.Ctx.Err() != nil {
+					w.finish(r, r.Ctx.Err())
+					continue
+				}
 
-				// The initial bridge, which is nil for the first call
-				var bridgeConnection *BridgeConnection
+				err := w.process(r)
+				w.compensate(r, err)
+				w.finish(r, err)
+			}
 
-				// Single request processing channel
-				ch := make(chan *Response)
+		}
+	}()
+}
+
+// This method runs the tasks of the request one after another, the response of a task is passed to the next one
+// through the bridge. It returns an error, if the request is stopped before all the tasks are run.
+func (w *Worker) process(r *Request) error {
 
-				currentTask := r.Tasks[0]
-				currentTimer := time.NewTimer(currentTask.Timeout)
-				doTask(ch, currentTask, bridgeConnection)
+	// Create a slice of response with equal size of the number of requests
+	r.Responses = make([]*Response, 0, len(r.Tasks))
 
-				w.loop(currentTimer, r, bridgeConnection, currentTask, ch)
+	// The response of the previous task, which is nil for the first one
+	var parent *Response
 
+	for index, task := range r.Tasks {
+		var bridge Bridge
+		allowNil := false
+		if index > 0 {
+			bridge = r.Bridges[index-1]
+
+			// A history bridge does not need the data of the previous response, it can take from the earlier ones
+			if historyBridge := r.historyBridge(index - 1); historyBridge != nil {
+				bridge = func(interface{}) *BridgeConnection { return historyBridge(r.history()) }
+				allowNil = true
+			}
+		}
+		response, broken, err := w.step(r, index, len(r.Tasks)-index, task, bridge, parent, allowNil)
+		if err != nil {
+			return err
+		}
+		if broken {
+			for i := index; i < len(r.Tasks); i++ {
+				r.Responses = append(r.Responses, bridgeError(response.Error))
 			}
+			return nil
+		}
+		r.Responses = append(r.Responses, response)
+		parent = response
+	}
+	return nil
+}
 
+// This method runs a step of the chain, the task with the data bridged from the parent response. The remaining is
+// the number of the tasks left in the request, including this one, among which the deadline is split. It tells if
+// the chain is broken by the bridge, then the response has the error of the bridge.
+//
+// The parent data must not be nil, unless it is allowed, like for the first task of a branch, which can be chosen
+// for a failed response.
+func (w *Worker) step(r *Request, index, remaining int, task *FutureTask, bridge Bridge, parent *Response, allowNil bool) (*Response, bool, error) {
+	if task.Predicate != nil {
+		return w.branch(r, index, remaining, task, parent)
+	}
+
+	if parent != nil && parent.Data == nil && !allowNil {
+		w.balancer.logger.Printf("Cannot proceed the chain, the response from the parent call is nil")
+		return nil, false, errors.New("the chain is broken, the response from the parent call is nil")
+	}
+	if task.ForEach != nil {
+		return w.forEach(r, index, remaining, task, parent)
+	}
+
+	var bridgeConnection *BridgeConnection
+	if parent != nil {
+		if bridge == nil {
+			w.balancer.logger.Printf("Cannot access bridge as it is nil, check your bridge configuration")
+			return nil, false, errors.New("the chain is broken, the bridge is nil")
 		}
-	}()
+		bridgeConnection = bridge(parent.Data)
+		if bridgeConnection.Error != nil {
+			return bridgeError(bridgeConnection.Error), true, nil
+		}
+	} else if r.Input != nil {
+		bridgeConnection = &BridgeConnection{Data: r.Input}
+	}
+
+	response, attempt, err := w.run(r, index, remaining, task, bridgeConnection)
+	if err != nil {
+		return nil, false, err
+	}
+	r.progress(TaskCompleted, index, task, attempt, response)
+	return response, false, nil
 }
 
-// This method handles the individual tasks and its timeout and the request context
-func (w *Worker) loop(currentTimer *time.Timer, r *Request, bridgeConnection *BridgeConnection, currentTask *FutureTask, ch chan *Response) {
-	for {
+// The response in place of a task, which is not run as the bridge to it has failed
+func bridgeError(err error) *Response {
+	return &Response{
+		ResponseTime: -1,
+		ResponseCode: -1,
+		Data:         nil,
+		Error:        err,
+	}
+}
+
+// This method runs a task and, when it fails or times out, its fallback task or default value in its place
+func (w *Worker) run(r *Request, index, remaining int, task *FutureTask, bridgeConnection *BridgeConnection) (*Response, int, error) {
+	response, attempt, err := w.execute(r, index, remaining, task, bridgeConnection)
+	if !timedOut(err) && (err != nil || response.Error == nil) {
+		return response, attempt, err
+	}
+
+	if task.Fallback != nil {
+		w.balancer.logger.Printf("Running the fallback task of : %s", task.Name)
+		fallback, fallbackAttempt, fallbackErr := w.run(r, index, remaining, task.Fallback, bridgeConnection)
+		if fallbackErr == nil && fallback.Error == nil {
+			fallback.Fallback = true
+			return fallback, fallbackAttempt, nil
+		}
+		if fallbackErr != nil && !timedOut(fallbackErr) {
+			return nil, attempt, fallbackErr
+		}
+	}
+
+	if task.Default != nil {
+		return &Response{Data: task.Default, Fallback: true}, attempt, nil
+	}
+	return response, attempt, err
+}
+
+// This method runs a task, handling its timeout, retries and the request context. It returns the final response and
+// the number of attempts made.
+//
+// When the request context has a deadline, the task gets its share of the remaining time, split evenly among the
+// remaining tasks. So a task, which finishes early, leaves more time for the later ones. The attempts are cut down to
+// the share, and a retry is not made, when the rest of the share is shorter than the failed attempt took.
+func (w *Worker) execute(r *Request, index, remaining int, task *FutureTask, bridgeConnection *BridgeConnection) (*Response, int, error) {
+	breaker := w.balancer.CircuitBreaker(task.Name)
+	bulkhead := w.balancer.Bulkhead(task.Bulkhead)
+	limiters := []*RateLimiter{w.balancer.TenantRateLimiter(r.Tenant), w.balancer.TaskRateLimiter(task.Name)}
+	share, hasBudget := taskShare(r, remaining)
+
+	// The last failed response, which is retried
+	var last *Response
+
+	for attempt := 1; ; attempt++ {
+		timeout := w.balancer.timeoutOf(task)
+		if hasBudget {
+			left := time.Until(share)
+			if left <= 0 || (last != nil && left < last.ResponseTime) {
+				w.balancer.logger.Printf("The request deadline can not cover the next call of the task : %s", task.Name)
+				if last != nil {
+					return last, attempt - 1, nil
+				}
+				r.progress(TaskTimedOut, index, task, attempt, nil)
+				return nil, attempt, ErrBudgetExhausted
+			}
+			if left < timeout {
+				timeout = left
+			}
+		}
+
+		// A rate limit, an open circuit or a full bulkhead fails the task without calling it, there is no point in
+		// retrying. The tokens taken are refunded, when a later check fails, as the call is not made.
+		for i, limiter := range limiters {
+			if err := limiter.take(r.Ctx, timeout); err != nil {
+				refund(limiters[:i])
+				if err != ErrRateLimited {
+					return nil, attempt, err
+				}
+				w.balancer.logger.Printf("Rate limit is exceeded for the task : %s", task.Name)
+				return &Response{ResponseCode: -1, Error: ErrRateLimited}, attempt, nil
+			}
+		}
+		generation, allowed := breaker.allow()
+		if !allowed {
+			refund(limiters)
+			w.balancer.logger.Printf("Circuit is open for the task : %s", task.Name)
+			return &Response{ResponseCode: -1, Error: ErrCircuitOpen}, attempt, nil
+		}
+		if err := bulkhead.acquire(r.Ctx); err != nil {
+			breaker.release(generation)
+			refund(limiters)
+			if err != ErrBulkheadFull {
+				return nil, attempt, err
+			}
+			w.balancer.logger.Printf("Bulkhead is full for the task : %s", task.Name)
+			return &Response{ResponseCode: -1, Error: ErrBulkheadFull}, attempt, nil
+		}
+
+		r.progress(TaskStarted, index, task, attempt, nil)
+
+		// The channel is buffered, so an abandoned call does not block forever. The bulkhead slot is held till the
+		// call actually returns, even if the task times out before that.
+		ch := make(chan *Response, 1)
+		end := w.balancer.trace(r, task, attempt)
+		timer := time.NewTimer(timeout)
+		switch {
+		case task.Pipeline != nil:
+			w.doPipeline(ch, r, task, bridgeConnection, timeout, bulkhead.release)
+		case task.Exec != nil:
+			doExec(ch, r, task, bridgeConnection, timeout, bulkhead.release)
+		case task.HTTP != nil:
+			doHTTP(ch, r, task, bridgeConnection, timeout, bulkhead.release)
+		default:
+			doTask(ch, task, bridgeConnection, bulkhead.release)
+		}
+
 		select {
 		case <-r.Ctx.Done():
-			log.Println("Context cancelled")
-			w.done <- w
-			r.CompletedChannel <- true
-			return
-		case <-currentTimer.C:
-			log.Println("Timeout")
-			w.done <- w
-			r.CompletedChannel <- true
-			return
+			timer.Stop()
+			breaker.release(generation)
+			end(nil, r.Ctx.Err())
+			w.balancer.logger.Printf("Context cancelled")
+			return nil, attempt, r.Ctx.Err()
+		case <-timer.C:
+			breaker.record(generation, timeout, true)
+			end(nil, ErrTimeout)
+			w.balancer.logger.Printf("Timeout")
+			r.progress(TaskTimedOut, index, task, attempt, nil)
+			return nil, attempt, ErrTimeout
 		case response := <-ch:
-			currentTimer.Stop()
-			if len(r.Tasks)-1 == 0 {
-				if response.Error != nil && currentTask.RetryCount > 0 {
-					currentTask.RetryCount--
-					log.Println("Retrying task")
-					currentTimer = time.NewTimer(currentTask.Timeout)
-					doTask(ch, currentTask, bridgeConnection)
-				} else {
-					r.Responses = append(r.Responses, response)
-					w.done <- w
-					r.CompletedChannel <- true
-					return
+			timer.Stop()
+			breaker.record(generation, response.ResponseTime, response.Error != nil)
+			end(response, nil)
+			if response.Error != nil && retryable(response.Error) && attempt <= w.balancer.retriesOf(task) {
+				w.balancer.logger.Printf("Retrying task")
+				r.progress(TaskRetried, index, task, attempt, response)
+				last = response
+				if err := w.backoff(r, attempt); err != nil {
+					return nil, attempt, err
 				}
+				continue
+			}
+			return response, attempt, nil
+		}
+	}
+}
 
-			} else {
-				if response.Error != nil && currentTask.RetryCount > 0 {
-					currentTask.RetryCount--
-					log.Println("Retrying task")
-					currentTimer = time.NewTimer(currentTask.Timeout)
-					doTask(ch, currentTask, bridgeConnection)
-				} else {
-					r.Responses = append(r.Responses, response)
-					r.Tasks = r.Tasks[1:]
-					bridge := r.Bridges[0]
-					if len(r.Bridges) > 1 {
-						r.Bridges = r.Bridges[1:]
-					}
-					if bridge == nil {
-						log.Printf("Cannot access bridge as it is nil, check your bridge configuration")
-						return
-					}
-					if response.Data == nil {
-						log.Printf("Cannot proceed the chain, the response from the parent call is nil")
-						return
-					}
-					bridgeConnection = bridge(response.Data)
-
-					if bridgeConnection.Error == nil {
-						currentTask = r.Tasks[0]
-						currentTimer = time.NewTimer(currentTask.Timeout)
-						doTask(ch, currentTask, bridgeConnection)
-					} else {
-						for i := 0; i < len(r.Tasks); i++ {
-							r.Responses = append(r.Responses, &Response{
-								ResponseTime: -1,
-								ResponseCode: -1,
-								Data:         nil,
-								Error:        bridgeConnection.Error,
-							})
-						}
-						w.done <- w
-						r.CompletedChannel <- true
-						return
-					}
-				}
+// Waits before a retry, as per the retry policy of the balancer
+func (w *Worker) backoff(r *Request, attempt int) error {
+	backoff := w.balancer.backoffOf(attempt)
+	if backoff <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.Ctx.Done():
+		return r.Ctx.Err()
+	}
+}
 
-			}
+// The end of the share of the task in the remaining time of the request, split among the remaining tasks. It is false
+// when there is no deadline.
+func taskShare(r *Request, remaining int) (time.Time, bool) {
+	deadline, ok := r.Ctx.Deadline()
+	if !ok {
+		return time.Time{}, false
+	}
+	now := time.Now()
+	return now.Add(deadline.Sub(now) / time.Duration(remaining)), true
+}
 
-		}
+// Tells if the error is of a task running out of time
+func timedOut(err error) bool {
+	return err == ErrTimeout || err == ErrBudgetExhausted
+}
+
+// Tells if the failed response can be retried, see RetryableError
+func retryable(err error) bool {
+	if e, ok := err.(RetryableError); ok {
+		return e.Retryable()
 	}
+	return true
+}
+
+// Lets the balancer and the caller know, that the request is processed
+func (w *Worker) finish(r *Request, err error) {
+	w.balancer.report(r, err)
+	w.done <- w
+	r.complete(err)
 }
 
 // This method handles the execution of the actual network call
-func doTask(ch chan *Response, task *FutureTask, bridgeConnection *BridgeConnection) {
+func doTask(ch chan *Response, task *FutureTask, bridgeConnection *BridgeConnection, release func()) {
 	// The actual network call happens here
 	go func() {
+		defer release()
+		if task.Scatter != nil {
+			ch <- task.Scatter.gather(bridgeConnection)
+			return
+		}
+
 		var futureTaskResponse *FutureTaskResponse
 		preTime := time.Now()
 		if task.ReplicaCount > 1 {
-			replicaChannel := make(chan *FutureTaskResponse)
-			for i := 0; i < task.RetryCount; i++ {
+			// The replicas, which respond after the fastest one, must not block, hence the buffer
+			replicaChannel := make(chan *FutureTaskResponse, task.ReplicaCount)
+			for i := 0; i < task.ReplicaCount; i++ {
 				go func() { replicaChannel <- task.Callback(bridgeConnection) }()
 			}
 			futureTaskResponse = <-replicaChannel
-			close(replicaChannel)
 		} else {
 			futureTaskResponse = task.Callback(bridgeConnection)
 		}
@@ -0,0 +1,203 @@
+package rio
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFallbackTaskAndDefaultValue(t *testing.T) {
+	balancer := GetBalancer(2, 2)
+
+	release := make(chan bool)
+	defer close(release)
+
+	failing := func(*BridgeConnection) *FutureTaskResponse {
+		return &FutureTaskResponse{ResponseCode: 500, Error: errors.New("backend down")}
+	}
+
+	request := BuildRequests(context.Background(),
+		NewFutureTask(failing).WithSecondTimeout(10).WithRetry(1).
+			WithFallback(NewFutureTask(Task4).WithSecondTimeout(10))).
+		FollowedBy(Bridge5, NewFutureTask(blockingTask(release)).WithMilliSecondTimeout(20).WithDefault("Cached")).
+		FollowedBy(Bridge5, NewFutureTask(Task6).WithSecondTimeout(10))
+
+	future, _ := balancer.Submit(request)
+	responses, err := future.Wait(context.Background())
+	if err != nil || len(responses) != 3 {
+		t.Fatal("The chain should continue with the fallback responses", err)
+	}
+	if !responses[0].Fallback || responses[0].Data.(string) != "Response 4" || responses[0].Error != nil {
+		t.Fail()
+	}
+	if !responses[1].Fallback || responses[1].Data.(string) != "Cached" {
+		t.Fail()
+	}
+	if responses[2].Fallback || len(responses[2].Data.([]interface{})) != 2 {
+		t.Fail()
+	}
+
+	// Without a default, a failed fallback leaves the original failure in place
+	request = BuildRequests(context.Background(),
+		NewFutureTask(failing).WithSecondTimeout(10).WithFallback(NewFutureTask(failing).WithSecondTimeout(10)))
+	future, _ = balancer.Submit(request)
+	responses, err = future.Wait(context.Background())
+	if err != nil || responses[0].Fallback || responses[0].Error == nil {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestRequestDeadlineSplitAcrossTasks(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	release := make(chan bool)
+	defer close(release)
+
+	request := BuildRequests(context.Background(), NewFutureTask(sleepingTask(20*time.Millisecond, nil)).WithSecondTimeout(10)).
+		FollowedBy(Bridge5, NewFutureTask(blockingTask(release)).WithSecondTimeout(10)).
+		FollowedBy(Bridge5, NewFutureTask(Task6).WithSecondTimeout(10)).
+		WithTimeout(300 * time.Millisecond)
+
+	start := time.Now()
+	future, _ := balancer.Submit(request)
+	responses, err := future.Wait(context.Background())
+	elapsed := time.Since(start)
+
+	// The second task gets half of the time left after the first one, leaving the rest for the third
+	if err != ErrTimeout || len(responses) != 1 || elapsed > 200*time.Millisecond || elapsed < 100*time.Millisecond {
+		t.Errorf("Unexpected outcome : %v, %d responses in %v", err, len(responses), elapsed)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestRequestDeadlineStopsRetries(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	var calls int32
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	request := BuildRequests(ctx, NewFutureTask(sleepingTask(80*time.Millisecond, errors.New("failed"))).
+		WithSecondTimeout(10).WithRetry(5).WithFallback(NewFutureTask(func(bconn *BridgeConnection) *FutureTaskResponse {
+		atomic.AddInt32(&calls, 1)
+		return Task4(bconn)
+	}).WithSecondTimeout(10)))
+
+	future, _ := balancer.Submit(request)
+	responses, err := future.Wait(context.Background())
+
+	// The third attempt can not fit in the 40ms left, so the failure goes to the fallback, which is quick enough
+	if err != nil || !responses[0].Fallback || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Unexpected outcome : %v, %v", err, responses)
+	}
+
+	// The call is cut down to the request deadline, even though the task timeout is longer
+	request = BuildRequests(context.Background(), NewFutureTask(sleepingTask(60*time.Millisecond, nil)).WithSecondTimeout(10)).
+		FollowedBy(Bridge5, NewFutureTask(Task6).WithSecondTimeout(10).WithDefault("Default")).
+		WithTimeout(50 * time.Millisecond)
+	future, _ = balancer.Submit(request)
+	if responses, err := future.Wait(context.Background()); err != ErrTimeout || len(responses) != 0 {
+		t.Errorf("Unexpected outcome : %v, %v", err, responses)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func sleepingTask(d time.Duration, err error) Callback {
+	return func(*BridgeConnection) *FutureTaskResponse {
+		time.Sleep(d)
+		return &FutureTaskResponse{ResponseCode: 200, Data: "Slept", Error: err}
+	}
+}
+
+func TestZeroAndDefaultTimeouts(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	// A task without a timeout waits for its callback
+	future, _ := balancer.Submit(BuildRequests(context.Background(), NewFutureTask(sleepingTask(20*time.Millisecond, nil))))
+	if responses, err := future.Wait(context.Background()); err != nil || responses[0].Data.(string) != "Slept" {
+		t.Fail()
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+
+	balancer = GetBalancerWithTimeouts(1, 1, 30*time.Millisecond, 0)
+
+	future, _ = balancer.Submit(BuildRequests(context.Background(), NewFutureTask(sleepingTask(60*time.Millisecond, nil))))
+	if _, err := future.Wait(context.Background()); err != ErrTimeout {
+		t.Fail()
+	}
+	future, _ = balancer.Submit(BuildRequests(context.Background(),
+		NewFutureTask(sleepingTask(60*time.Millisecond, nil)).WithNoTimeout()))
+	if _, err := future.Wait(context.Background()); err != nil {
+		t.Fail()
+	}
+
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestReplicasAreCalledReplicaCountTimes(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	// The first replica responds, the late ones must neither block nor panic on sending their responses
+	var calls, finished int32
+	task := NewFutureTask(func(*BridgeConnection) *FutureTaskResponse {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		atomic.AddInt32(&finished, 1)
+		return &FutureTaskResponse{ResponseCode: 200, Data: "replica"}
+	}).WithReplica(3)
+
+	future, _ := balancer.Submit(BuildRequests(context.Background(), task))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	responses, err := future.Wait(ctx)
+	if err != nil || responses[0].Data != "replica" {
+		t.Fatalf("unexpected result : %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&finished) < 3 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 3 || atomic.LoadInt32(&finished) != 3 {
+		t.Errorf("expected 3 replica calls, got %d", calls)
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
+
+func TestStepFallbackIsRejected(t *testing.T) {
+	balancer := GetBalancer(1, 1)
+
+	// The steps have no callback, running them as a fallback would call a nil callback
+	fallbacks := []*FutureTask{
+		{Predicate: cacheBranch, Branches: []*Branch{NewBranch("hit", passBridge, NewFutureTask(Task1))}},
+		{ForEach: &ForEach{FanOut: idsFanOut, Task: NewFutureTask(Task1)}},
+	}
+	for _, fallback := range fallbacks {
+		request := BuildRequests(context.Background(), NewFutureTask(Task1).WithFallback(fallback))
+		if _, err := balancer.Submit(request); err == nil || !strings.Contains(err.Error(), "can not be a branch or a fan out step") {
+			t.Errorf("unexpected error : %v", err)
+		}
+	}
+
+	closeChannel := make(chan bool)
+	balancer.Close(closeChannel)
+	<-closeChannel
+}
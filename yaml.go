@@ -0,0 +1,322 @@
+package rio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The parser of the YAML subset, used for the configuration and the pipeline files. It supports the block maps and
+// lists, the comments, the plain, single and double quoted scalars and the flow lists of scalars, like [a, b]. The
+// anchors, the tags, the multi line scalars and the flow maps are not supported.
+//
+// The maps are parsed to map[string]interface{}, the lists to []interface{} and the scalars to string, int64,
+// float64, bool or nil, like the JSON values.
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("yaml line %d : tabs are not allowed for the indentation", i+1)
+		}
+		p.lines = append(p.lines, &yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	value, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.position < len(p.lines) {
+		line := p.lines[p.position]
+		return nil, fmt.Errorf("yaml line %d : unexpected indentation", line.number)
+	}
+	return value, nil
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines    []*yamlLine
+	position int
+}
+
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLListItem(p.lines[p.position].text) {
+		return p.parseList(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	result := make(map[string]interface{})
+	for p.position < len(p.lines) {
+		line := p.lines[p.position]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("yaml line %d : unexpected indentation", line.number)
+		}
+		if isYAMLListItem(line.text) {
+			return nil, fmt.Errorf("yaml line %d : a list item is not expected here", line.number)
+		}
+		key, rest, err := splitYAMLKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := result[key]; found {
+			return nil, fmt.Errorf("yaml line %d : the key %q is repeated", line.number, key)
+		}
+		p.position++
+
+		if rest != "" {
+			if result[key], err = parseYAMLScalar(rest, line.number); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// The value is a nested block, or a list at the same indentation as the key, or nothing
+		if p.position < len(p.lines) {
+			next := p.lines[p.position]
+			if next.indent > indent || (next.indent == indent && isYAMLListItem(next.text)) {
+				if result[key], err = p.parseBlock(next.indent); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		result[key] = nil
+	}
+	return result, nil
+}
+
+func (p *yamlParser) parseList(indent int) (interface{}, error) {
+	result := make([]interface{}, 0)
+	for p.position < len(p.lines) {
+		line := p.lines[p.position]
+		if line.indent < indent || (line.indent == indent && !isYAMLListItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("yaml line %d : unexpected indentation", line.number)
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+
+		if rest == "" {
+			p.position++
+			if p.position < len(p.lines) && p.lines[p.position].indent > indent {
+				item, err := p.parseBlock(p.lines[p.position].indent)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, item)
+			} else {
+				result = append(result, nil)
+			}
+			continue
+		}
+
+		// An item like "- key: value" starts a map, which continues on the lines indented like its first key
+		if isYAMLListItem(rest) || isYAMLMapEntry(rest) {
+			p.lines[p.position] = &yamlLine{number: line.number, indent: line.indent + len(line.text) - len(rest), text: rest}
+			item, err := p.parseBlock(p.lines[p.position].indent)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, item)
+			continue
+		}
+
+		item, err := parseYAMLScalar(rest, line.number)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+		p.position++
+	}
+	return result, nil
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func isYAMLMapEntry(text string) bool {
+	return yamlKeyEnd(text) >= 0
+}
+
+// The index of the colon, which ends the key of a map entry, it is -1 when the text is not a map entry
+func yamlKeyEnd(text string) int {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		end := closingQuote(text)
+		if end < 0 || end+1 >= len(text) || text[end+1] != ':' {
+			return -1
+		}
+		if end+2 < len(text) && text[end+2] != ' ' {
+			return -1
+		}
+		return end + 1
+	}
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return -1
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+func splitYAMLKey(line *yamlLine) (string, string, error) {
+	end := yamlKeyEnd(line.text)
+	if end < 0 {
+		return "", "", fmt.Errorf("yaml line %d : expected a key, like key: value", line.number)
+	}
+	key := strings.TrimSpace(line.text[:end])
+	if strings.HasPrefix(key, "\"") || strings.HasPrefix(key, "'") {
+		unquoted, err := parseYAMLScalar(key, line.number)
+		if err != nil {
+			return "", "", err
+		}
+		key = unquoted.(string)
+	}
+	return key, strings.TrimSpace(line.text[end+1:]), nil
+}
+
+func parseYAMLScalar(text string, number int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "\""):
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("yaml line %d : unterminated string %s", number, text)
+		}
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("yaml line %d : invalid string %s", number, text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("yaml line %d : unterminated string %s", number, text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("yaml line %d : unterminated list %s", number, text)
+		}
+		items := make([]interface{}, 0)
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		if inner == "" {
+			return items, nil
+		}
+		for _, part := range splitFlowList(inner) {
+			item, err := parseYAMLScalar(strings.TrimSpace(part), number)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case strings.HasPrefix(text, "{"):
+		if text == "{}" {
+			return make(map[string]interface{}), nil
+		}
+		return nil, fmt.Errorf("yaml line %d : flow maps are not supported", number)
+	}
+
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f, nil
+	}
+	return text, nil
+}
+
+// The index of the quote closing the quoted text at the start, it is -1 when there is none
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// Splits the items of a flow list on the commas, which are not in a quoted item. A quote starts the quoted item only
+// at the start of the item, like in stripYAMLComment. An unterminated quote is left to the parsing of the item.
+func splitFlowList(inner string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case c == ',':
+			parts = append(parts, inner[start:i])
+			start = i + 1
+		case (c == '"' || c == '\'') && strings.TrimSpace(inner[start:i]) == "":
+			if end := closingQuote(inner[i:]); end != -1 {
+				i += end
+			} else {
+				i = len(inner)
+			}
+		}
+	}
+	return append(parts, inner[start:])
+}
+
+// Removes the comment from the line, a comment starts with a # at the start or after a space, outside the quotes. A
+// quote starts the quoted text only at the start of a value, so the one in don't is a part of the plain text.
+func stripYAMLComment(line string) string {
+	var quote byte
+	valueStart := true
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == '\'' && quote == '\'' && i+1 < len(line) && line[i+1] == '\'' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case (c == '"' || c == '\'') && valueStart:
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+		switch {
+		case c == ' ' || c == '\t':
+			valueStart = valueStart || i > 0 && strings.IndexByte(":-?", line[i-1]) >= 0
+		default:
+			valueStart = strings.IndexByte("[{,", c) >= 0
+		}
+	}
+	return line
+}
@@ -0,0 +1,84 @@
+package rio
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	data := `
+# The comment lines and the trailing comments are skipped
+name: pipeline   # trailing
+count: 3
+ratio: 0.5
+enabled: true
+missing: ~
+quoted: "a # not a comment: really"
+single: 'it''s'
+plain: don't # the apostrophe does not start a quote
+list: [a, 2, "c"]
+args: ["a,b", c, 'd, e', "f\", g"]
+tasks:
+  - name: first
+    retries: 1
+  - name: second
+    tags:
+      - x
+      - y
+edges:
+- from: first
+  to: second
+nested:
+  inner:
+    deep: value
+  empty:
+`
+	parsed, err := parseYAML([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"name":    "pipeline",
+		"count":   int64(3),
+		"ratio":   0.5,
+		"enabled": true,
+		"missing": nil,
+		"quoted":  "a # not a comment: really",
+		"single":  "it's",
+		"plain":   "don't",
+		"list":    []interface{}{"a", int64(2), "c"},
+		"args":    []interface{}{"a,b", "c", "d, e", "f\", g"},
+		"tasks": []interface{}{
+			map[string]interface{}{"name": "first", "retries": int64(1)},
+			map[string]interface{}{"name": "second", "tags": []interface{}{"x", "y"}},
+		},
+		"edges": []interface{}{
+			map[string]interface{}{"from": "first", "to": "second"},
+		},
+		"nested": map[string]interface{}{
+			"inner": map[string]interface{}{"deep": "value"},
+			"empty": nil,
+		},
+	}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("unexpected result : %#v", parsed)
+	}
+}
+
+func TestParseInvalidYAML(t *testing.T) {
+	invalid := map[string]string{
+		"a: 1\n  b: 2":       "line 2",
+		"a: 1\na: 2":         "line 2",
+		"a:\n  - x\n  y: 1":  "line 3",
+		"a: \"open":          "line 1",
+		"a: {b: 1}":          "line 1",
+		"a: [\"x, y]":        "line 1",
+		"just a scalar line": "line 1",
+	}
+	for data, line := range invalid {
+		if _, err := parseYAML([]byte(data)); err == nil || !strings.Contains(err.Error(), line) {
+			t.Errorf("%q : unexpected error %v", data, err)
+		}
+	}
+}